@@ -52,7 +52,7 @@ func main() {
 	}()
 
 	for i := 0; i < c.ComputingPower; i++ {
-		go worker.Work(taskCh, client, c.ApiUrl)
+		go worker.Work(taskCh, client, c.ApiUrl, c.MaxResultBits)
 	}
 
 	<-shutdownCh
@@ -10,12 +10,16 @@ import (
 const (
 	RequestTimeout   = 5 * time.Second
 	WorkerPoolBuffer = 20
+	// DefaultMaxResultBits allows exact results of about 315 thousand decimal digits
+	DefaultMaxResultBits = 1 << 20
 )
 
 type Config struct {
 	ApiUrl         string
 	ComputingPower int
 	WaitTime       int
+	// MaxResultBits limits the size of exact results, operations with larger results fail
+	MaxResultBits int
 }
 
 func New() *Config {
@@ -35,9 +39,18 @@ func New() *Config {
 		waitTime = 10
 	}
 
+	maxResultBits := DefaultMaxResultBits
+	if maxResultBitsStr := os.Getenv("MAX_RESULT_BITS"); maxResultBitsStr != "" {
+		var err error
+		if maxResultBits, err = strconv.Atoi(maxResultBitsStr); err != nil || maxResultBits <= 0 {
+			log.Fatalf("MAX_RESULT_BITS must be a positive integer, got %q\n", maxResultBitsStr)
+		}
+	}
+
 	return &Config{
 		ApiUrl:         apiUrl,
 		ComputingPower: power,
 		WaitTime:       waitTime,
+		MaxResultBits:  maxResultBits,
 	}
 }
@@ -2,15 +2,62 @@ package models
 
 const ERROR = "ERROR"
 
+const (
+	// ModeDecimal tasks get their arguments as decimal strings in Operands and are computed exactly
+	ModeDecimal = "decimal"
+	// ModeBigint tasks get their arguments as integer strings in Operands, results must be integers
+	ModeBigint = "bigint"
+	// ModeComplex tasks get their arguments in ComplexArgs and send the result as Complex
+	ModeComplex = "complex"
+)
+
+const (
+	// ErrorKindFatal is sent for deterministic errors, the orchestrator fails the expression
+	ErrorKindFatal = "FATAL"
+	// ErrorKindTransient is sent for temporary errors, the orchestrator retries the task
+	ErrorKindTransient = "TRANSIENT"
+)
+
+const (
+	ErrorCodeDivisionByZero   = "DIVISION_BY_ZERO"
+	ErrorCodeUndefinedResult  = "UNDEFINED_RESULT"
+	ErrorCodeInvalidArguments = "INVALID_ARGUMENTS"
+	ErrorCodeInexactResult    = "INEXACT_RESULT"
+	ErrorCodeUnknownOperation = "UNKNOWN_OPERATION"
+	ErrorCodeResultTooLarge   = "RESULT_TOO_LARGE"
+	ErrorCodeTimeout          = "TIMEOUT"
+	ErrorCodeDeliveryFailed   = "DELIVERY_FAILED"
+)
+
 type TaskResponse struct {
-	ID            string  `json:"id"`
-	Arg1          float64 `json:"arg1"`
-	Arg2          float64 `json:"arg2"`
-	Operation     string  `json:"operation"`
-	OperationTime int     `json:"operation_time"`
+	ID   string  `json:"id"`
+	Arg1 float64 `json:"arg1"`
+	Arg2 float64 `json:"arg2"`
+	// Args are the arguments of functions, Arg1 and Arg2 are used by operators
+	Args []float64 `json:"args,omitempty"`
+	// Mode is empty for float tasks
+	Mode string `json:"mode,omitempty"`
+	// Precision is the number of digits after the decimal point kept in results without a finite decimal form
+	Precision int `json:"precision,omitempty"`
+	// Operands are the arguments of exact tasks in the order of Arg1 and Arg2 or of Args
+	Operands []string `json:"operands,omitempty"`
+	// ComplexArgs are the arguments of complex tasks in the order of Arg1 and Arg2 or of Args
+	ComplexArgs   []Complex `json:"complex_args,omitempty"`
+	Operation     string    `json:"operation"`
+	OperationTime int       `json:"operation_time"`
+	Lease         string    `json:"lease"`
+}
+
+type Complex struct {
+	Real float64 `json:"real"`
+	Imag float64 `json:"imag"`
 }
 
 type TaskRequest struct {
-	ID     string      `json:"id"`
-	Result interface{} `json:"result"`
+	ID           string      `json:"id"`
+	Result       interface{} `json:"result"`
+	Lease        string      `json:"lease"`
+	ErrorKind    string      `json:"error_kind,omitempty"`
+	ErrorCode    string      `json:"error_code,omitempty"`
+	ErrorMessage string      `json:"error_message,omitempty"`
 }
@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"agent/internal/models"
+	"fmt"
+	"math/big"
+)
+
+// calculateBigint is a method for applying the operation of an exact task to its integer operands,
+// the operations of the decimal mode are reused and results that are not integers are rejected
+func calculateBigint(task *models.TaskResponse, maxResultBits int) (string, error) {
+	args := make([]*big.Rat, 0, len(task.Operands))
+	for _, operand := range task.Operands {
+		arg, ok := new(big.Int).SetString(operand, 10)
+		if !ok {
+			return "", fmt.Errorf("%w: %q is not an integer", invalidOperandError, operand)
+		}
+		args = append(args, new(big.Rat).SetInt(arg))
+	}
+
+	if task.Operation == "sqrt" {
+		if len(args) != 1 {
+			return "", fmt.Errorf("%w: sqrt expects 1 argument, got %d", invalidArgumentsError, len(args))
+		}
+		return bigintSqrt(args[0].Num())
+	}
+
+	result, err := decimalOperation(task.Operation, args, maxResultBits)
+	if err != nil {
+		return "", err
+	}
+	// 7 / 2 and 2 ^ -1 have no integer result
+	if !result.IsInt() {
+		return "", fmt.Errorf("%w: %s", inexactResultError, task.Operation)
+	}
+	return result.Num().String(), nil
+}
+
+// bigintSqrt is a method for computing the square root of a perfect square
+func bigintSqrt(arg *big.Int) (string, error) {
+	if arg.Sign() < 0 {
+		return "", fmt.Errorf("%w: sqrt", undefinedResultError)
+	}
+
+	root := new(big.Int).Sqrt(arg)
+	if new(big.Int).Mul(root, root).Cmp(arg) != 0 {
+		return "", fmt.Errorf("%w: sqrt", inexactResultError)
+	}
+	return root.String(), nil
+}
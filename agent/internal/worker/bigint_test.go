@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"agent/internal/models"
+	"errors"
+	"testing"
+)
+
+func TestCalculateBigint(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		operands  []string
+		// maxResultBits is testMaxResultBits when zero
+		maxResultBits int
+		want          string
+		wantErr       error
+	}{
+		{name: "sum over uint64", operation: "+", operands: []string{"18446744073709551615", "1"}, want: "18446744073709551616"},
+		{name: "product over uint64", operation: "*", operands: []string{"18446744073709551616", "18446744073709551616"}, want: "340282366920938463463374607431768211456"},
+		{name: "negative difference", operation: "-", operands: []string{"1", "100000000000000000000"}, want: "-99999999999999999999"},
+		{name: "exact quotient", operation: "/", operands: []string{"-8", "2"}, want: "-4"},
+		{name: "integer division of negative dividend", operation: "//", operands: []string{"-7", "2"}, want: "-4"},
+		{name: "integer division of negative divisor", operation: "//", operands: []string{"7", "-2"}, want: "-4"},
+		{name: "integer division of negative operands", operation: "//", operands: []string{"-7", "-2"}, want: "3"},
+		{name: "modulo of negative dividend", operation: "%", operands: []string{"-7", "3"}, want: "-1"},
+		{name: "modulo of negative divisor", operation: "%", operands: []string{"7", "-3"}, want: "1"},
+		{name: "modulo of negative operands", operation: "%", operands: []string{"-7", "-3"}, want: "-1"},
+		{name: "power", operation: "^", operands: []string{"-2", "3"}, want: "-8"},
+		{name: "negative power of one", operation: "^", operands: []string{"1", "-5"}, want: "1"},
+		{name: "negative power of minus one", operation: "pow", operands: []string{"-1", "-3"}, want: "-1"},
+		{name: "abs", operation: "abs", operands: []string{"-100000000000000000000"}, want: "100000000000000000000"},
+		{name: "min", operation: "min", operands: []string{"3", "-100000000000000000000", "2"}, want: "-100000000000000000000"},
+		{name: "max", operation: "max", operands: []string{"3", "100000000000000000000", "2"}, want: "100000000000000000000"},
+		{name: "sqrt", operation: "sqrt", operands: []string{"152415787532388367501905199875019052100"}, want: "12345678901234567890"},
+		{name: "inexact quotient", operation: "/", operands: []string{"7", "2"}, wantErr: inexactResultError},
+		{name: "inexact negative power", operation: "^", operands: []string{"2", "-1"}, wantErr: inexactResultError},
+		{name: "inexact sqrt", operation: "sqrt", operands: []string{"2"}, wantErr: inexactResultError},
+		{name: "sqrt of negative number", operation: "sqrt", operands: []string{"-4"}, wantErr: undefinedResultError},
+		{name: "division by zero", operation: "/", operands: []string{"1", "0"}, wantErr: divisionByZeroError},
+		{name: "integer division by zero", operation: "//", operands: []string{"-1", "0"}, wantErr: divisionByZeroError},
+		{name: "modulo by zero", operation: "%", operands: []string{"-1", "0"}, wantErr: divisionByZeroError},
+		{name: "fractional operand", operation: "+", operands: []string{"1.5", "1"}, wantErr: invalidOperandError},
+		{name: "scientific notation", operation: "+", operands: []string{"1e3", "1"}, wantErr: invalidOperandError},
+		{name: "sqrt of two arguments", operation: "sqrt", operands: []string{"4", "9"}, wantErr: invalidArgumentsError},
+		{name: "power over default limit", operation: "^", operands: []string{"10", "1000000"}, wantErr: resultTooLargeError},
+		{name: "minimal int64 exponent", operation: "^", operands: []string{"10", "-9223372036854775808"}, wantErr: resultTooLargeError},
+		{name: "exponent out of int64", operation: "pow", operands: []string{"-10", "100000000000000000000"}, wantErr: resultTooLargeError},
+		{name: "product over limit", operation: "*", operands: []string{"100000000000000000000", "100000000000000000000"}, maxResultBits: 100, wantErr: resultTooLargeError},
+		{name: "integer quotient under limit", operation: "//", operands: []string{"100000000000000000000", "3"}, maxResultBits: 100, want: "33333333333333333333"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxResultBits := tt.maxResultBits
+			if maxResultBits == 0 {
+				maxResultBits = testMaxResultBits
+			}
+			task := &models.TaskResponse{Mode: models.ModeBigint, Operation: tt.operation, Operands: tt.operands}
+			got, err := calculateBigint(task, maxResultBits)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"agent/internal/models"
+	"fmt"
+	"math/cmplx"
+)
+
+// complexFunctions are the functions of one complex argument, abs returns the modulus
+var complexFunctions = map[string]func(complex128) complex128{
+	"sqrt":  cmplx.Sqrt,
+	"abs":   func(z complex128) complex128 { return complex(cmplx.Abs(z), 0) },
+	"ln":    cmplx.Log,
+	"log10": cmplx.Log10,
+	"sin":   cmplx.Sin,
+	"cos":   cmplx.Cos,
+}
+
+// calculateComplex is a method for calculating the result of a complex task
+func calculateComplex(task *models.TaskResponse) (models.Complex, error) {
+	args := make([]complex128, 0, len(task.ComplexArgs))
+	for _, arg := range task.ComplexArgs {
+		args = append(args, complex(arg.Real, arg.Imag))
+	}
+
+	result, err := complexOperation(task.Operation, args)
+	if err != nil {
+		return models.Complex{}, err
+	}
+	// 0^-1 and overflows have no finite result that can be sent as JSON
+	if cmplx.IsInf(result) || cmplx.IsNaN(result) {
+		return models.Complex{}, fmt.Errorf("%w: %s", undefinedResultError, task.Operation)
+	}
+	return models.Complex{Real: real(result), Imag: imag(result)}, nil
+}
+
+// complexOperation is a method for applying an operator or a function to complex arguments,
+// the remainder, the rounded quotient, min and max are not defined for complex numbers
+func complexOperation(operation string, args []complex128) (complex128, error) {
+	switch operation {
+	case "+", "-", "*", "/", "^", "pow":
+		if len(args) != 2 {
+			return 0, fmt.Errorf("%w: %s expects 2 operands, got %d", invalidArgumentsError, operation, len(args))
+		}
+	}
+
+	switch operation {
+	case "+":
+		return args[0] + args[1], nil
+	case "-":
+		return args[0] - args[1], nil
+	case "*":
+		return args[0] * args[1], nil
+	case "/":
+		if args[1] == 0 {
+			return 0, divisionByZeroError
+		}
+		return args[0] / args[1], nil
+	case "^", "pow":
+		return cmplx.Pow(args[0], args[1]), nil
+	}
+
+	f, ok := complexFunctions[operation]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", unknownOperationError, operation)
+	}
+	if len(args) != 1 {
+		return 0, fmt.Errorf("%w: %s expects 1 argument, got %d", invalidArgumentsError, operation, len(args))
+	}
+	return f(args[0]), nil
+}
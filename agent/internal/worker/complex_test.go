@@ -0,0 +1,53 @@
+package worker
+
+import (
+	"agent/internal/models"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCalculateComplex(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		args      []models.Complex
+		want      models.Complex
+		wantErr   error
+	}{
+		{name: "sum", operation: "+", args: []models.Complex{{Real: 1, Imag: 2}, {Real: 3, Imag: -4}}, want: models.Complex{Real: 4, Imag: -2}},
+		{name: "difference", operation: "-", args: []models.Complex{{Real: 1, Imag: 2}, {Real: 3, Imag: -4}}, want: models.Complex{Real: -2, Imag: 6}},
+		{name: "product", operation: "*", args: []models.Complex{{Real: 1, Imag: 2}, {Real: 3, Imag: -4}}, want: models.Complex{Real: 11, Imag: 2}},
+		{name: "quotient", operation: "/", args: []models.Complex{{Real: 2, Imag: 2}, {Real: 1, Imag: 1}}, want: models.Complex{Real: 2}},
+		{name: "square of imaginary unit", operation: "^", args: []models.Complex{{Imag: 1}, {Real: 2}}, want: models.Complex{Real: -1}},
+		{name: "imaginary power", operation: "pow", args: []models.Complex{{Real: math.E}, {Imag: math.Pi}}, want: models.Complex{Real: -1}},
+		{name: "sqrt of negative number", operation: "sqrt", args: []models.Complex{{Real: -4}}, want: models.Complex{Imag: 2}},
+		{name: "sqrt of imaginary number", operation: "sqrt", args: []models.Complex{{Imag: 2}}, want: models.Complex{Real: 1, Imag: 1}},
+		{name: "abs", operation: "abs", args: []models.Complex{{Real: 3, Imag: 4}}, want: models.Complex{Real: 5}},
+		{name: "ln of negative number", operation: "ln", args: []models.Complex{{Real: -1}}, want: models.Complex{Imag: math.Pi}},
+		{name: "sqrt below branch cut", operation: "sqrt", args: []models.Complex{{Real: -4, Imag: math.Copysign(0, -1)}}, want: models.Complex{Imag: -2}},
+		{name: "ln below branch cut", operation: "ln", args: []models.Complex{{Real: -1, Imag: math.Copysign(0, -1)}}, want: models.Complex{Imag: -math.Pi}},
+		{name: "principal cube root of negative number", operation: "^", args: []models.Complex{{Real: -8}, {Real: 1.0 / 3}}, want: models.Complex{Real: 1, Imag: math.Sqrt(3)}},
+		{name: "division by zero", operation: "/", args: []models.Complex{{Real: 1, Imag: 1}, {}}, wantErr: divisionByZeroError},
+		{name: "negative power of zero", operation: "^", args: []models.Complex{{}, {Real: -1}}, wantErr: undefinedResultError},
+		{name: "overflow", operation: "*", args: []models.Complex{{Real: 1e308}, {Real: 10}}, wantErr: undefinedResultError},
+		{name: "ln of zero", operation: "ln", args: []models.Complex{{}}, wantErr: undefinedResultError},
+		{name: "modulo", operation: "%", args: []models.Complex{{Real: 7}, {Real: 2}}, wantErr: unknownOperationError},
+		{name: "integer division", operation: "//", args: []models.Complex{{Real: 7}, {Real: 2}}, wantErr: unknownOperationError},
+		{name: "min", operation: "min", args: []models.Complex{{Real: 1}, {Real: 2}}, wantErr: unknownOperationError},
+		{name: "missing operand", operation: "^", args: []models.Complex{{Real: 1}}, wantErr: invalidArgumentsError},
+		{name: "sqrt of two arguments", operation: "sqrt", args: []models.Complex{{Real: 1}, {Real: 2}}, wantErr: invalidArgumentsError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := calculateComplex(&models.TaskResponse{Mode: models.ModeComplex, Operation: tt.operation, ComplexArgs: tt.args})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if math.Abs(got.Real-tt.want.Real) > 1e-12 || math.Abs(got.Imag-tt.want.Imag) > 1e-12 {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
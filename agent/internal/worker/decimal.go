@@ -0,0 +1,226 @@
+package worker
+
+import (
+	"agent/internal/models"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+var (
+	decimalTwo  = big.NewInt(2)
+	decimalFive = big.NewInt(5)
+)
+
+// calculateDecimal is a method for applying the operation of an exact task to its decimal operands,
+// results without a finite decimal form are rounded to the precision of the task
+func calculateDecimal(task *models.TaskResponse, maxResultBits int) (string, error) {
+	args := make([]*big.Rat, 0, len(task.Operands))
+	for _, operand := range task.Operands {
+		arg, ok := new(big.Rat).SetString(operand)
+		if !ok {
+			return "", fmt.Errorf("%w: %q is not a decimal number", invalidOperandError, operand)
+		}
+		args = append(args, arg)
+	}
+
+	if task.Operation == "sqrt" {
+		if len(args) != 1 {
+			return "", fmt.Errorf("%w: sqrt expects 1 argument, got %d", invalidArgumentsError, len(args))
+		}
+		return decimalSqrt(args[0], task.Precision)
+	}
+
+	result, err := decimalOperation(task.Operation, args, maxResultBits)
+	if err != nil {
+		return "", err
+	}
+	return formatDecimal(result, task.Precision), nil
+}
+
+// decimalOperation is a method for computing the exact result of an operator or a function,
+// operations whose result would take more than maxResultBits are rejected before computing it
+func decimalOperation(operation string, args []*big.Rat, maxResultBits int) (*big.Rat, error) {
+	switch operation {
+	case "+", "-", "*", "/", "%", "//", "^":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%w: %s expects 2 operands, got %d", invalidArgumentsError, operation, len(args))
+		}
+		return decimalOperator(operation, args[0], args[1], maxResultBits)
+	case "abs":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%w: abs expects 1 argument, got %d", invalidArgumentsError, len(args))
+		}
+		return new(big.Rat).Abs(args[0]), nil
+	case "pow":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%w: pow expects 2 arguments, got %d", invalidArgumentsError, len(args))
+		}
+		return decimalPow(args[0], args[1], maxResultBits)
+	case "min", "max":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%w: %s expects at least 1 argument", invalidArgumentsError, operation)
+		}
+		result := args[0]
+		for _, arg := range args[1:] {
+			if operation == "min" && arg.Cmp(result) < 0 || operation == "max" && arg.Cmp(result) > 0 {
+				result = arg
+			}
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", unknownOperationError, operation)
+	}
+}
+
+// decimalOperator is a method for applying an operator with the same rules as in the float mode
+func decimalOperator(operation string, a, b *big.Rat, maxResultBits int) (*big.Rat, error) {
+	switch operation {
+	case "+":
+		return new(big.Rat).Add(a, b), nil
+	case "-":
+		return new(big.Rat).Sub(a, b), nil
+	case "*":
+		if err := checkResultBits(operation, productBits(a.Num(), b.Num(), a.Denom(), b.Denom()), maxResultBits); err != nil {
+			return nil, err
+		}
+		return new(big.Rat).Mul(a, b), nil
+	case "^":
+		return decimalPow(a, b, maxResultBits)
+	}
+
+	if b.Sign() == 0 {
+		return nil, divisionByZeroError
+	}
+	// the quotient multiplies the numerator of a by the denominator of b and the other way round
+	if err := checkResultBits(operation, productBits(a.Num(), b.Denom(), a.Denom(), b.Num()), maxResultBits); err != nil {
+		return nil, err
+	}
+	quotient := new(big.Rat).Quo(a, b)
+	switch operation {
+	case "/":
+		return quotient, nil
+	case "%":
+		// the result has the sign of the dividend: -7 % 3 is -1
+		truncated := new(big.Int).Quo(quotient.Num(), quotient.Denom())
+		return new(big.Rat).Sub(a, new(big.Rat).Mul(b, new(big.Rat).SetInt(truncated))), nil
+	default:
+		// the quotient is rounded down: -7 // 2 is -4, the denominator is always positive
+		return new(big.Rat).SetInt(new(big.Int).Div(quotient.Num(), quotient.Denom())), nil
+	}
+}
+
+// decimalPow is a method for raising to an integer power, other exponents have no exact result.
+// The result of a base other than 0, 1 and -1 takes about as many bits as the base times the exponent
+func decimalPow(base, exponent *big.Rat, maxResultBits int) (*big.Rat, error) {
+	if !exponent.IsInt() {
+		return nil, fmt.Errorf("%w: the exponent must be an integer", invalidOperandError)
+	}
+
+	if base.Sign() == 0 {
+		switch exponent.Sign() {
+		case -1:
+			return nil, fmt.Errorf("%w: ^", undefinedResultError)
+		case 0:
+			return big.NewRat(1, 1), nil
+		default:
+			return new(big.Rat), nil
+		}
+	}
+	if base.IsInt() && base.Num().CmpAbs(big.NewInt(1)) == 0 {
+		// (-1)^n is -1 for odd exponents only
+		if base.Sign() < 0 && exponent.Num().Bit(0) == 1 {
+			return big.NewRat(-1, 1), nil
+		}
+		return big.NewRat(1, 1), nil
+	}
+
+	// the bound is compared without converting the exponent, so exponents out of int64 and -2^63 are rejected too
+	bits := max(base.Num().BitLen(), base.Denom().BitLen())
+	if exponent.Num().CmpAbs(big.NewInt(int64(maxResultBits/bits))) > 0 {
+		return nil, fmt.Errorf("%w: ^ with the exponent %s takes more than %d bits", resultTooLargeError, exponent.Num(), maxResultBits)
+	}
+
+	power := new(big.Int).Abs(exponent.Num())
+	num := new(big.Int).Exp(base.Num(), power, nil)
+	denom := new(big.Int).Exp(base.Denom(), power, nil)
+	if exponent.Sign() < 0 {
+		num, denom = denom, num
+	}
+	return new(big.Rat).SetFrac(num, denom), nil
+}
+
+// productBits is a method for estimating the bits of the numerator and the denominator of a product,
+// the larger of them is returned
+func productBits(num1, num2, denom1, denom2 *big.Int) int {
+	return max(num1.BitLen()+num2.BitLen(), denom1.BitLen()+denom2.BitLen())
+}
+
+// checkResultBits is a method for rejecting an operation whose result takes more than maxResultBits
+func checkResultBits(operation string, bits, maxResultBits int) error {
+	if bits > maxResultBits {
+		return fmt.Errorf("%w: %s takes about %d bits, the limit is %d", resultTooLargeError, operation, bits, maxResultBits)
+	}
+	return nil
+}
+
+// decimalSqrt is a method for computing a square root rounded to the precision
+func decimalSqrt(arg *big.Rat, precision int) (string, error) {
+	if arg.Sign() < 0 {
+		return "", fmt.Errorf("%w: sqrt", undefinedResultError)
+	}
+
+	// a few more bits than the digits of the precision need, so the rounding is correct
+	bits := uint(float64(precision)*math.Log2(10)) + uint(arg.Num().BitLen()) + 64
+	root := new(big.Float).SetPrec(bits).SetRat(arg)
+	root.Sqrt(root)
+
+	result, _ := root.Rat(nil)
+	return roundDecimal(result, precision), nil
+}
+
+// formatDecimal is a method for formatting the result, finite decimals are kept exactly
+func formatDecimal(value *big.Rat, precision int) string {
+	if digits, ok := decimalDigits(value.Denom()); ok {
+		return roundDecimal(value, digits)
+	}
+	return roundDecimal(value, precision)
+}
+
+// decimalDigits is a method for counting the digits after the decimal point of a fraction with the denominator,
+// false is returned when the fraction has no finite decimal form
+func decimalDigits(denom *big.Int) (int, bool) {
+	rest := new(big.Int).Set(denom)
+	remainder := new(big.Int)
+	twos, fives := 0, 0
+	for {
+		quotient, mod := new(big.Int).QuoRem(rest, decimalTwo, remainder)
+		if mod.Sign() != 0 {
+			break
+		}
+		rest = quotient
+		twos++
+	}
+	for {
+		quotient, mod := new(big.Int).QuoRem(rest, decimalFive, remainder)
+		if mod.Sign() != 0 {
+			break
+		}
+		rest = quotient
+		fives++
+	}
+	return max(twos, fives), rest.IsInt64() && rest.Int64() == 1
+}
+
+// roundDecimal is a method for rounding half away from zero without trailing zeros
+func roundDecimal(value *big.Rat, digits int) string {
+	result := value.FloatString(digits)
+	if strings.Contains(result, ".") {
+		result = strings.TrimRight(strings.TrimRight(result, "0"), ".")
+	}
+	if result == "-0" {
+		return "0"
+	}
+	return result
+}
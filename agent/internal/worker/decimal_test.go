@@ -0,0 +1,150 @@
+package worker
+
+import (
+	"agent/internal/models"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// testMaxResultBits is the default limit of the agent
+const testMaxResultBits = 1 << 20
+
+func TestCalculateDecimal(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		operands  []string
+		precision int
+		// maxResultBits is testMaxResultBits when zero
+		maxResultBits int
+		want          string
+		wantErr       error
+	}{
+		{name: "exact sum", operation: "+", operands: []string{"0.1", "0.2"}, want: "0.3"},
+		{name: "scientific notation", operation: "+", operands: []string{"1e-30", "0"}, want: "0.000000000000000000000000000001"},
+		{name: "trailing zeros", operation: "*", operands: []string{"2.50", "4"}, want: "10"},
+		{name: "rounded quotient", operation: "/", operands: []string{"2", "3"}, precision: 3, want: "0.667"},
+		{name: "rounded negative quotient", operation: "/", operands: []string{"-2", "3"}, precision: 3, want: "-0.667"},
+		{name: "finite quotient beyond precision", operation: "/", operands: []string{"1", "8"}, precision: 2, want: "0.125"},
+		{name: "quotient rounded to zero", operation: "/", operands: []string{"-1", "3e30"}, precision: 5, want: "0"},
+		{name: "modulo of negative dividend", operation: "%", operands: []string{"-7", "3"}, want: "-1"},
+		{name: "modulo of fractions", operation: "%", operands: []string{"7.5", "2"}, want: "1.5"},
+		{name: "integer division of negative dividend", operation: "//", operands: []string{"-7", "2"}, want: "-4"},
+		{name: "integer division of fractions", operation: "//", operands: []string{"7.5", "0.5"}, want: "15"},
+		{name: "power", operation: "^", operands: []string{"2", "100"}, want: "1267650600228229401496703205376"},
+		{name: "negative power", operation: "^", operands: []string{"2", "-3"}, want: "0.125"},
+		{name: "negative power of fraction", operation: "pow", operands: []string{"0.5", "-2"}, want: "4"},
+		{name: "zero power of zero", operation: "^", operands: []string{"0", "0"}, want: "1"},
+		{name: "huge power of minus one", operation: "^", operands: []string{"-1", "100000000000000000001"}, want: "-1"},
+		{name: "abs", operation: "abs", operands: []string{"-0.5"}, want: "0.5"},
+		{name: "min", operation: "min", operands: []string{"1.5", "-2", "1.50001"}, want: "-2"},
+		{name: "max", operation: "max", operands: []string{"1.5", "-2", "1.50001"}, want: "1.50001"},
+		{name: "sqrt", operation: "sqrt", operands: []string{"2"}, precision: 10, want: "1.4142135624"},
+		{name: "exact sqrt", operation: "sqrt", operands: []string{"0.25"}, precision: 20, want: "0.5"},
+		{name: "division by zero", operation: "/", operands: []string{"1", "0.0"}, wantErr: divisionByZeroError},
+		{name: "modulo by zero", operation: "%", operands: []string{"1", "0"}, wantErr: divisionByZeroError},
+		{name: "integer division by zero", operation: "//", operands: []string{"1", "0"}, wantErr: divisionByZeroError},
+		{name: "power of zero with negative exponent", operation: "^", operands: []string{"0", "-1"}, wantErr: undefinedResultError},
+		{name: "fractional exponent", operation: "^", operands: []string{"2", "0.5"}, wantErr: invalidOperandError},
+		{name: "sqrt of negative number", operation: "sqrt", operands: []string{"-1"}, wantErr: undefinedResultError},
+		{name: "invalid operand", operation: "+", operands: []string{"1", "one"}, wantErr: invalidOperandError},
+		{name: "missing operand", operation: "+", operands: []string{"1"}, wantErr: invalidArgumentsError},
+		{name: "sqrt of two arguments", operation: "sqrt", operands: []string{"1", "2"}, wantErr: invalidArgumentsError},
+		{name: "inexact function", operation: "ln", operands: []string{"2"}, wantErr: unknownOperationError},
+		{name: "power over limit", operation: "^", operands: []string{"2", "1000"}, maxResultBits: 1000, wantErr: resultTooLargeError},
+		{name: "power under limit", operation: "^", operands: []string{"2", "499"}, maxResultBits: 1000, want: "1636695303948070935006594848413799576108321023021532394741645684048066898202337277441635046162952078575443342063780035504608628272942696526664263794688"},
+		{name: "exponent out of int64", operation: "^", operands: []string{"2", "1e30"}, wantErr: resultTooLargeError},
+		{name: "minimal int64 exponent", operation: "^", operands: []string{"2", "-9223372036854775808"}, wantErr: resultTooLargeError},
+		{name: "negative exponent out of int64", operation: "pow", operands: []string{"0.5", "-1e30"}, wantErr: resultTooLargeError},
+		{name: "product over limit", operation: "*", operands: []string{"1e200", "1e200"}, maxResultBits: 1000, wantErr: resultTooLargeError},
+		{name: "quotient over limit", operation: "/", operands: []string{"1e200", "1e-200"}, maxResultBits: 1000, wantErr: resultTooLargeError},
+		{name: "integer quotient over limit", operation: "//", operands: []string{"1e200", "1e-200"}, maxResultBits: 1000, wantErr: resultTooLargeError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxResultBits := tt.maxResultBits
+			if maxResultBits == 0 {
+				maxResultBits = testMaxResultBits
+			}
+			task := &models.TaskResponse{Mode: models.ModeDecimal, Operation: tt.operation, Operands: tt.operands, Precision: tt.precision}
+			got, err := calculateDecimal(task, maxResultBits)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFormatDecimal(t *testing.T) {
+	tests := []struct {
+		value     *big.Rat
+		precision int
+		want      string
+	}{
+		{big.NewRat(1, 3), 4, "0.3333"},
+		{big.NewRat(2, 3), 4, "0.6667"},
+		{big.NewRat(5, 2), 0, "2.5"},
+		{big.NewRat(-1, 4), 1, "-0.25"},
+		{big.NewRat(1, 1024), 2, "0.0009765625"},
+		{big.NewRat(12, 1), 4, "12"},
+		{big.NewRat(-1, 300000), 3, "0"},
+	}
+
+	for _, tt := range tests {
+		if got := formatDecimal(tt.value, tt.precision); got != tt.want {
+			t.Errorf("formatDecimal(%s, %d) = %q, want %q", tt.value, tt.precision, got, tt.want)
+		}
+	}
+}
+
+func TestDecimalDigits(t *testing.T) {
+	tests := []struct {
+		denom      int64
+		wantDigits int
+		wantOk     bool
+	}{
+		{1, 0, true},
+		{8, 3, true},
+		{20, 2, true},
+		{625, 4, true},
+		{3, 0, false},
+		{12, 2, false},
+	}
+
+	for _, tt := range tests {
+		digits, ok := decimalDigits(big.NewInt(tt.denom))
+		if ok != tt.wantOk || ok && digits != tt.wantDigits {
+			t.Errorf("decimalDigits(%d) = %d, %v, want %d, %v", tt.denom, digits, ok, tt.wantDigits, tt.wantOk)
+		}
+	}
+}
+
+func TestDecimalSqrt(t *testing.T) {
+	tests := []struct {
+		value     *big.Rat
+		precision int
+		want      string
+	}{
+		{big.NewRat(2, 1), 5, "1.41421"},
+		{big.NewRat(3, 1), 1, "1.7"},
+		{big.NewRat(1, 9), 3, "0.333"},
+		{big.NewRat(16, 1), 20, "4"},
+		{big.NewRat(0, 1), 20, "0"},
+		{new(big.Rat).SetFrac(new(big.Int).Exp(big.NewInt(10), big.NewInt(40), nil), big.NewInt(1)), 20, "100000000000000000000"},
+	}
+
+	for _, tt := range tests {
+		got, err := decimalSqrt(tt.value, tt.precision)
+		if err != nil {
+			t.Fatalf("decimalSqrt(%s) failed: %v", tt.value, err)
+		}
+		if got != tt.want {
+			t.Errorf("decimalSqrt(%s, %d) = %q, want %q", tt.value, tt.precision, got, tt.want)
+		}
+	}
+}
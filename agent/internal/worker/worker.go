@@ -6,21 +6,43 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"time"
 )
 
-// Work is a main worker method
-func Work(taskCh <-chan struct{}, client *http.Client, apiUrl string) {
+var (
+	divisionByZeroError   = errors.New("division by zero")
+	undefinedResultError  = errors.New("result is not a finite number")
+	unknownOperationError = errors.New("unknown operation")
+	invalidArgumentsError = errors.New("invalid number of arguments")
+	invalidOperandError   = errors.New("invalid operand")
+	inexactResultError    = errors.New("result is not an integer")
+	resultTooLargeError   = errors.New("result is too large")
+)
+
+// unaryFunctions are the functions of one argument
+var unaryFunctions = map[string]func(float64) float64{
+	"sqrt":  math.Sqrt,
+	"abs":   math.Abs,
+	"ln":    math.Log,
+	"log10": math.Log10,
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+}
+
+// Work is a main worker method, exact results larger than maxResultBits are rejected
+func Work(taskCh <-chan struct{}, client *http.Client, apiUrl string, maxResultBits int) {
 	for range taskCh {
-		processTask(client, apiUrl)
+		processTask(client, apiUrl, maxResultBits)
 	}
 }
 
 // processTask is a method for processing task in a worker
-func processTask(client *http.Client, apiUrl string) {
+func processTask(client *http.Client, apiUrl string, maxResultBits int) {
 	task, err := getTask(client, apiUrl)
 	if err != nil {
 		logger.Log.Infof("Error getting task: %v\n", err)
@@ -33,11 +55,11 @@ func processTask(client *http.Client, apiUrl string) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(task.OperationTime)*time.Millisecond)
 	defer cancel()
 
-	resultChan := make(chan float64, 1)
+	resultChan := make(chan interface{}, 1)
 	errorChan := make(chan error, 1)
 
 	go func() {
-		res, err := calculateResult(task)
+		res, err := calculateTask(task, maxResultBits)
 		if err != nil {
 			errorChan <- err
 			return
@@ -48,18 +70,18 @@ func processTask(client *http.Client, apiUrl string) {
 	select {
 	case <-ctx.Done():
 		logger.Log.Infof("Task %s timed out", task.ID)
-		if err := sendResult(client, apiUrl, task.ID, models.ERROR); err != nil {
+		if err := sendError(client, apiUrl, task, models.ErrorKindTransient, models.ErrorCodeTimeout, ctx.Err()); err != nil {
 			logger.Log.Infof("Error sending timeout result: %v\n", err)
 		}
 	case err := <-errorChan:
 		logger.Log.Infof("Error calculating result: %v\n", err)
-		if err := sendResult(client, apiUrl, task.ID, models.ERROR); err != nil {
+		if err := sendError(client, apiUrl, task, models.ErrorKindFatal, errorCode(err), err); err != nil {
 			logger.Log.Infof("Error sending error result: %v\n", err)
 		}
 	case result := <-resultChan:
-		if err := sendResult(client, apiUrl, task.ID, result); err != nil {
+		if err := sendResult(client, apiUrl, task, result); err != nil {
 			logger.Log.Infof("Error sending result: %v\n", err)
-			_ = sendResult(client, apiUrl, task.ID, models.ERROR)
+			_ = sendError(client, apiUrl, task, models.ErrorKindTransient, models.ErrorCodeDeliveryFailed, err)
 		}
 	}
 }
@@ -84,8 +106,38 @@ func getTask(client *http.Client, apiUrl string) (*models.TaskResponse, error) {
 	return &task, nil
 }
 
+// calculateTask is a method for calculating the result of a task in its mode,
+// exact results are sent as strings
+func calculateTask(task *models.TaskResponse, maxResultBits int) (interface{}, error) {
+	switch task.Mode {
+	case "":
+		return calculateResult(task)
+	case models.ModeDecimal:
+		return calculateDecimal(task, maxResultBits)
+	case models.ModeBigint:
+		return calculateBigint(task, maxResultBits)
+	case models.ModeComplex:
+		return calculateComplex(task)
+	default:
+		return nil, fmt.Errorf("%w: mode %s", unknownOperationError, task.Mode)
+	}
+}
+
 // calculateResult is a method for calculating result of task
 func calculateResult(task *models.TaskResponse) (float64, error) {
+	result, err := calculate(task)
+	if err != nil {
+		return 0, err
+	}
+	// 0^-1, sqrt(-1) and overflows have no real result that can be sent as JSON
+	if math.IsInf(result, 0) || math.IsNaN(result) {
+		return 0, fmt.Errorf("%w: %s", undefinedResultError, task.Operation)
+	}
+	return result, nil
+}
+
+// calculate is a method for applying the operation or the function of task to its arguments
+func calculate(task *models.TaskResponse) (float64, error) {
 	switch task.Operation {
 	case "+":
 		return task.Arg1 + task.Arg2, nil
@@ -95,21 +147,99 @@ func calculateResult(task *models.TaskResponse) (float64, error) {
 		return task.Arg1 * task.Arg2, nil
 	case "/":
 		if task.Arg2 == 0 {
-			return 0, fmt.Errorf("division by zero")
+			return 0, divisionByZeroError
 		}
 		return task.Arg1 / task.Arg2, nil
+	case "%":
+		if task.Arg2 == 0 {
+			return 0, divisionByZeroError
+		}
+		// the result has the sign of the dividend: -7 % 3 is -1
+		return math.Mod(task.Arg1, task.Arg2), nil
+	case "//":
+		if task.Arg2 == 0 {
+			return 0, divisionByZeroError
+		}
+		// the quotient is rounded down: -7 // 2 is -4
+		return math.Floor(task.Arg1 / task.Arg2), nil
+	case "^":
+		return math.Pow(task.Arg1, task.Arg2), nil
+	}
+
+	if f, ok := unaryFunctions[task.Operation]; ok {
+		if len(task.Args) != 1 {
+			return 0, fmt.Errorf("%w: %s expects 1 argument, got %d", invalidArgumentsError, task.Operation, len(task.Args))
+		}
+		return f(task.Args[0]), nil
+	}
+
+	switch task.Operation {
+	case "pow":
+		if len(task.Args) != 2 {
+			return 0, fmt.Errorf("%w: pow expects 2 arguments, got %d", invalidArgumentsError, len(task.Args))
+		}
+		return math.Pow(task.Args[0], task.Args[1]), nil
+	case "min", "max":
+		if len(task.Args) == 0 {
+			return 0, fmt.Errorf("%w: %s expects at least 1 argument", invalidArgumentsError, task.Operation)
+		}
+		result := task.Args[0]
+		for _, arg := range task.Args[1:] {
+			if task.Operation == "min" {
+				result = math.Min(result, arg)
+			} else {
+				result = math.Max(result, arg)
+			}
+		}
+		return result, nil
 	default:
-		return 0, fmt.Errorf("unknown operation: %s", task.Operation)
+		return 0, fmt.Errorf("%w: %s", unknownOperationError, task.Operation)
+	}
+}
+
+// errorCode is a method for getting the API error code of a calculation error
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, divisionByZeroError):
+		return models.ErrorCodeDivisionByZero
+	case errors.Is(err, undefinedResultError):
+		return models.ErrorCodeUndefinedResult
+	case errors.Is(err, invalidArgumentsError), errors.Is(err, invalidOperandError):
+		return models.ErrorCodeInvalidArguments
+	case errors.Is(err, inexactResultError):
+		return models.ErrorCodeInexactResult
+	case errors.Is(err, unknownOperationError):
+		return models.ErrorCodeUnknownOperation
+	case errors.Is(err, resultTooLargeError):
+		return models.ErrorCodeResultTooLarge
+	default:
+		return ""
 	}
 }
 
 // sendResult is a method for sending calculation result to the API
-func sendResult(client *http.Client, apiUrl, taskID string, result interface{}) error {
-	data := models.TaskRequest{
-		ID:     taskID,
+func sendResult(client *http.Client, apiUrl string, task *models.TaskResponse, result interface{}) error {
+	return postTaskRequest(client, apiUrl, models.TaskRequest{
+		ID:     task.ID,
 		Result: result,
-	}
+		Lease:  task.Lease,
+	})
+}
+
+// sendError is a method for reporting a failed task to the API
+func sendError(client *http.Client, apiUrl string, task *models.TaskResponse, kind, code string, cause error) error {
+	return postTaskRequest(client, apiUrl, models.TaskRequest{
+		ID:           task.ID,
+		Result:       models.ERROR,
+		Lease:        task.Lease,
+		ErrorKind:    kind,
+		ErrorCode:    code,
+		ErrorMessage: cause.Error(),
+	})
+}
 
+// postTaskRequest is a method for posting a task request to the API
+func postTaskRequest(client *http.Client, apiUrl string, data models.TaskRequest) error {
 	body, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("marshal failed: %w", err)
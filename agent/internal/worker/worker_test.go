@@ -0,0 +1,114 @@
+package worker
+
+import (
+	"agent/internal/models"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCalculateResult(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		arg1      float64
+		arg2      float64
+		want      float64
+		wantErr   error
+	}{
+		{name: "addition", operation: "+", arg1: 2, arg2: 3, want: 5},
+		{name: "subtraction", operation: "-", arg1: 2, arg2: 3, want: -1},
+		{name: "multiplication", operation: "*", arg1: 2, arg2: 3, want: 6},
+		{name: "division", operation: "/", arg1: 7, arg2: 2, want: 3.5},
+		{name: "power", operation: "^", arg1: 2, arg2: 10, want: 1024},
+		{name: "modulo", operation: "%", arg1: 7, arg2: 3, want: 1},
+		{name: "modulo of negative dividend", operation: "%", arg1: -7, arg2: 3, want: -1},
+		{name: "modulo of negative divisor", operation: "%", arg1: 7, arg2: -3, want: 1},
+		{name: "modulo of fractions", operation: "%", arg1: 5.5, arg2: 2, want: 1.5},
+		{name: "integer division", operation: "//", arg1: 7, arg2: 2, want: 3},
+		{name: "integer division of negative dividend", operation: "//", arg1: -7, arg2: 2, want: -4},
+		{name: "integer division of negative divisor", operation: "//", arg1: 7, arg2: -2, want: -4},
+		{name: "division by zero", operation: "/", arg1: 1, arg2: 0, wantErr: divisionByZeroError},
+		{name: "modulo by zero", operation: "%", arg1: 1, arg2: 0, wantErr: divisionByZeroError},
+		{name: "integer division by zero", operation: "//", arg1: 1, arg2: 0, wantErr: divisionByZeroError},
+		{name: "power of zero with negative exponent", operation: "^", arg1: 0, arg2: -1, wantErr: undefinedResultError},
+		{name: "fractional power of negative number", operation: "^", arg1: -8, arg2: 1.0 / 3, wantErr: undefinedResultError},
+		{name: "huge exponent", operation: "^", arg1: 10, arg2: 400, wantErr: undefinedResultError},
+		{name: "overflow", operation: "*", arg1: 1e308, arg2: 10, wantErr: undefinedResultError},
+		{name: "unknown operation", operation: "&", arg1: 1, arg2: 2, wantErr: unknownOperationError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := calculateResult(&models.TaskResponse{Operation: tt.operation, Arg1: tt.arg1, Arg2: tt.arg2})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCalculateFunctions(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		args      []float64
+		want      float64
+		wantErr   error
+	}{
+		{name: "sqrt", operation: "sqrt", args: []float64{16}, want: 4},
+		{name: "abs", operation: "abs", args: []float64{-2.5}, want: 2.5},
+		{name: "ln", operation: "ln", args: []float64{math.E}, want: 1},
+		{name: "log10", operation: "log10", args: []float64{1000}, want: 3},
+		{name: "sin", operation: "sin", args: []float64{0}, want: 0},
+		{name: "cos", operation: "cos", args: []float64{0}, want: 1},
+		{name: "pow", operation: "pow", args: []float64{2, -2}, want: 0.25},
+		{name: "min", operation: "min", args: []float64{3, -1, 2}, want: -1},
+		{name: "max", operation: "max", args: []float64{3, -1, 2}, want: 3},
+		{name: "max of one argument", operation: "max", args: []float64{7}, want: 7},
+		{name: "sqrt of negative number", operation: "sqrt", args: []float64{-1}, wantErr: undefinedResultError},
+		{name: "ln of zero", operation: "ln", args: []float64{0}, wantErr: undefinedResultError},
+		{name: "log10 of negative number", operation: "log10", args: []float64{-10}, wantErr: undefinedResultError},
+		{name: "pow with huge exponent", operation: "pow", args: []float64{10, 400}, wantErr: undefinedResultError},
+		{name: "pow of zero with negative exponent", operation: "pow", args: []float64{0, -1}, wantErr: undefinedResultError},
+		{name: "sqrt without arguments", operation: "sqrt", wantErr: invalidArgumentsError},
+		{name: "abs of two arguments", operation: "abs", args: []float64{1, 2}, wantErr: invalidArgumentsError},
+		{name: "pow of one argument", operation: "pow", args: []float64{2}, wantErr: invalidArgumentsError},
+		{name: "min without arguments", operation: "min", wantErr: invalidArgumentsError},
+		{name: "unknown function", operation: "tan", args: []float64{1}, wantErr: unknownOperationError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := calculateResult(&models.TaskResponse{Operation: tt.operation, Args: tt.args})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := map[error]string{
+		divisionByZeroError:   models.ErrorCodeDivisionByZero,
+		undefinedResultError:  models.ErrorCodeUndefinedResult,
+		invalidArgumentsError: models.ErrorCodeInvalidArguments,
+		invalidOperandError:   models.ErrorCodeInvalidArguments,
+		inexactResultError:    models.ErrorCodeInexactResult,
+		unknownOperationError: models.ErrorCodeUnknownOperation,
+		resultTooLargeError:   models.ErrorCodeResultTooLarge,
+		errors.New("other"):   "",
+	}
+
+	for err, want := range tests {
+		if got := errorCode(err); got != want {
+			t.Errorf("errorCode(%v) = %q, want %q", err, got, want)
+		}
+	}
+}
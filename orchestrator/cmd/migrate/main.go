@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"orchestrator/internal/logger"
+	"orchestrator/internal/redisconf"
+	"orchestrator/internal/storage"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// migrate copies the data of the orchestrator from the legacy layout with separate
+// databases for expressions, results and tasks into namespaced keys of one database.
+// The connection is configured by the same REDIS_* variables as the orchestrator
+func main() {
+	logger.New(os.Getenv("DEBUG") == "TRUE", os.Getenv("TIMEZONE"))
+
+	opts, err := redisconf.NewOptions()
+	if err != nil {
+		logger.Log.Fatal(err)
+	}
+	if os.Getenv("REDIS_CLUSTER") == "TRUE" {
+		logger.Log.Fatal("Redis Cluster has a single database, the legacy layout can not be migrated there")
+	}
+
+	layout := storage.DefaultLegacyRedisLayout
+
+	addr := flag.String("addr", strings.Join(opts.Addrs, ","), "address of the Redis server or a comma separated list of sentinels")
+	flag.IntVar(&opts.DB, "db", opts.DB, "database to migrate the data into")
+	prefix := flag.String("prefix", getEnv("REDIS_PREFIX", storage.DefaultRedisPrefix), "prefix of the migrated keys")
+	flag.IntVar(&layout.ExpressionsDB, "expressions-db", layout.ExpressionsDB, "legacy database of expressions")
+	flag.IntVar(&layout.ResultsDB, "results-db", layout.ResultsDB, "legacy database of results")
+	flag.IntVar(&layout.TasksDB, "tasks-db", layout.TasksDB, "legacy database of tasks")
+	flag.Parse()
+
+	opts.Addrs = redisconf.ParseAddrs(*addr)
+
+	logger.Log.Infof("Migrating databases %d, %d and %d into database %d with prefix %q",
+		layout.ExpressionsDB, layout.ResultsDB, layout.TasksDB, opts.DB, *prefix)
+	if slices.Contains([]int{layout.ExpressionsDB, layout.ResultsDB, layout.TasksDB}, opts.DB) {
+		logger.Log.Warnf("Database %d is also a legacy database: the legacy keys stay next to the migrated ones, "+
+			"so FLUSHDB must NOT be run in it after the migration", opts.DB)
+	}
+
+	copied, err := storage.MigrateRedis(context.Background(), opts, layout, *prefix)
+	if err != nil {
+		logger.Log.Fatalf("Migration failed after %d keys: %v", copied, err)
+	}
+
+	flushable := flushableDBs(layout, opts.DB)
+	if len(flushable) == 0 {
+		logger.Log.Infof("Migrated %d keys, the legacy keys stay in database %d and must be deleted by name", copied, opts.DB)
+		return
+	}
+	logger.Log.Infof("Migrated %d keys, the legacy databases %s can be flushed now", copied, strings.Join(flushable, ", "))
+}
+
+// flushableDBs returns the legacy databases other than the target, only they hold no migrated keys
+func flushableDBs(layout storage.LegacyRedisLayout, target int) []string {
+	var dbs []string
+	for _, db := range []int{layout.ExpressionsDB, layout.ResultsDB, layout.TasksDB} {
+		if db != target && !slices.Contains(dbs, strconv.Itoa(db)) {
+			dbs = append(dbs, strconv.Itoa(db))
+		}
+	}
+	return dbs
+}
+
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
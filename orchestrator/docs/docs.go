@@ -19,8 +19,62 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/admin/expressions": {
+            "delete": {
+                "description": "Удаляет выражения со статусом DONE, ERROR или CANCELLED вместе с их задачами, выражения в процессе вычисления не удаляются",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "parameters": [
+                    {
+                        "enum": [
+                            "DONE",
+                            "ERROR",
+                            "CANCELLED"
+                        ],
+                        "type": "string",
+                        "description": "Статус выражения",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Удалить выражения, созданные раньше, чем указанное время назад, например 24h",
+                        "name": "older_than",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.PurgeResponse"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/models.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ApiError"
+                        }
+                    }
+                }
+            }
+        },
         "/api/v1/calculate": {
             "post": {
+                "description": "Если выражение уже вычислялось, возвращается его UUID. Чтобы вычислить его заново, передайте \"force\": true или заголовок Cache-Control: no-cache.\nВ режиме \"mode\": \"decimal\" выражение вычисляется точно, частные округляются до precision знаков после запятой, в режиме \"bigint\" - в целых числах любой длины, в режиме \"complex\" - в комплексных числах вида 1+2i",
                 "consumes": [
                     "application/json"
                 ],
@@ -39,6 +93,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/models.CalculateRequest"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "no-cache, чтобы не использовать кэш",
+                        "name": "Cache-Control",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -57,7 +117,7 @@ const docTemplate = `{
                     "422": {
                         "description": "Unprocessable Entity",
                         "schema": {
-                            "$ref": "#/definitions/models.ApiError"
+                            "$ref": "#/definitions/models.ExpressionSyntaxError"
                         }
                     },
                     "500": {
@@ -71,6 +131,7 @@ const docTemplate = `{
         },
         "/api/v1/expressions": {
             "get": {
+                "description": "Выражения отсортированы по времени создания, для получения следующей страницы передайте next_cursor",
                 "consumes": [
                     "application/json"
                 ],
@@ -80,6 +141,46 @@ const docTemplate = `{
                 "tags": [
                     "expressions"
                 ],
+                "parameters": [
+                    {
+                        "enum": [
+                            "DONE",
+                            "PROCESSING",
+                            "ERROR",
+                            "CANCELLED"
+                        ],
+                        "type": "string",
+                        "description": "Статус выражения",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "asc",
+                            "desc"
+                        ],
+                        "type": "string",
+                        "default": "desc",
+                        "description": "Порядок сортировки по времени создания",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "maximum": 500,
+                        "minimum": 1,
+                        "type": "integer",
+                        "default": 50,
+                        "description": "Количество выражений на странице",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Курсор следующей страницы",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -87,6 +188,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/models.ListAllExpressionsResponse"
                         }
                     },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/models.ApiError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -142,6 +249,59 @@ const docTemplate = `{
                         }
                     }
                 }
+            },
+            "delete": {
+                "description": "Задачи, ещё не отправленные агентам, удаляются, а результаты уже отправленных задач игнорируются",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "expressions"
+                ],
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "UUID выражения",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.GetByIdExpressionResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ApiError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/models.ApiError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/models.ApiError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ApiError"
+                        }
+                    }
+                }
             }
         },
         "/internal/task": {
@@ -210,6 +370,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/models.ApiError"
                         }
                     },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/models.ApiError"
+                        }
+                    },
                     "422": {
                         "description": "Unprocessable Entity",
                         "schema": {
@@ -247,6 +413,44 @@ const docTemplate = `{
                 "expression": {
                     "type": "string",
                     "example": "2+2"
+                },
+                "force": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "mode": {
+                    "description": "Mode is float by default, decimal and bigint compute the expression exactly, complex accepts imaginary numbers",
+                    "type": "string",
+                    "enum": [
+                        "float",
+                        "decimal",
+                        "bigint",
+                        "complex"
+                    ],
+                    "example": "decimal"
+                },
+                "numeric": {
+                    "description": "Numeric is an alias of Mode",
+                    "type": "string",
+                    "enum": [
+                        "float",
+                        "decimal",
+                        "bigint",
+                        "complex"
+                    ],
+                    "example": "bigint"
+                },
+                "precision": {
+                    "description": "Precision is the number of digits after the decimal point kept in quotients of the decimal mode",
+                    "type": "integer",
+                    "example": 20
+                },
+                "variables": {
+                    "description": "Variables are the values of identifiers in the expression, numbers or strings with numbers,\nthe exact modes keep all digits of the values",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "number"
+                    }
                 }
             }
         },
@@ -259,19 +463,139 @@ const docTemplate = `{
                 }
             }
         },
+        "models.Complex": {
+            "type": "object",
+            "properties": {
+                "imag": {
+                    "type": "number",
+                    "example": 2
+                },
+                "real": {
+                    "type": "number",
+                    "example": 11
+                }
+            }
+        },
         "models.Expression": {
             "type": "object",
             "properties": {
+                "complex": {
+                    "description": "Complex is the result in the complex mode",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.Complex"
+                        }
+                    ]
+                },
+                "created_at": {
+                    "type": "string",
+                    "example": "2025-03-01T12:00:00Z"
+                },
+                "error": {
+                    "$ref": "#/definitions/models.ExpressionError"
+                },
+                "expression": {
+                    "type": "string",
+                    "example": "2+2*2"
+                },
+                "finished_at": {
+                    "type": "string",
+                    "example": "2025-03-01T12:00:03Z"
+                },
                 "id": {
                     "type": "string",
                     "example": "928b303f-cfcc-46f4-ae24-aabb72bbb7d9"
                 },
+                "mode": {
+                    "type": "string",
+                    "enum": [
+                        "float",
+                        "decimal",
+                        "bigint",
+                        "complex"
+                    ],
+                    "example": "float"
+                },
+                "precision": {
+                    "type": "integer",
+                    "example": 20
+                },
                 "result": {
+                    "description": "Result is approximated by float64 in the exact modes and is the real part in the complex mode",
                     "type": "number"
                 },
+                "started_at": {
+                    "type": "string",
+                    "example": "2025-03-01T12:00:01Z"
+                },
                 "status": {
                     "type": "string",
+                    "enum": [
+                        "DONE",
+                        "PROCESSING",
+                        "ERROR",
+                        "CANCELLED"
+                    ],
                     "example": "DONE"
+                },
+                "task_count": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "value": {
+                    "description": "Value is the exact result in the decimal and bigint modes",
+                    "type": "string",
+                    "example": "0.3"
+                },
+                "variables": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "number"
+                    }
+                }
+            }
+        },
+        "models.ExpressionError": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "DIVISION_BY_ZERO"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "division by zero"
+                },
+                "operation": {
+                    "type": "string",
+                    "example": "/"
+                },
+                "task_id": {
+                    "type": "string",
+                    "example": "928b303f-cfcc-46f4-ae24-aabb72bbb7d9"
+                }
+            }
+        },
+        "models.ExpressionSyntaxError": {
+            "type": "object",
+            "properties": {
+                "column": {
+                    "description": "Column is the position of the offending token, starting from 1",
+                    "type": "integer",
+                    "example": 3
+                },
+                "message": {
+                    "type": "string",
+                    "example": "invalid expression: unexpected token \"*\" at column 3"
+                },
+                "status": {
+                    "type": "integer",
+                    "example": 422
+                },
+                "token": {
+                    "description": "Token is the offending token, empty at the end of the expression",
+                    "type": "string",
+                    "example": "*"
                 }
             }
         },
@@ -291,16 +615,49 @@ const docTemplate = `{
                     "items": {
                         "$ref": "#/definitions/models.Expression"
                     }
+                },
+                "next_cursor": {
+                    "type": "string",
+                    "example": "MTc0MDgzMDQwMDAwMDo5MjhiMzAzZi1jZmNjLTQ2ZjQtYWUyNC1hYWJiNzJiYmI3ZDk"
+                },
+                "total": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "models.PurgeResponse": {
+            "type": "object",
+            "properties": {
+                "deleted": {
+                    "type": "integer",
+                    "example": 10
                 }
             }
         },
         "models.TaskRequest": {
             "type": "object",
             "properties": {
+                "error_code": {
+                    "type": "string",
+                    "example": "TIMEOUT"
+                },
+                "error_kind": {
+                    "type": "string",
+                    "example": "TRANSIENT"
+                },
+                "error_message": {
+                    "type": "string",
+                    "example": "operation timed out"
+                },
                 "id": {
                     "type": "string",
                     "example": "928b303f-cfcc-46f4-ae24-aabb72bbb7d9"
                 },
+                "lease": {
+                    "type": "string",
+                    "example": "5b1c5b4e-3c2b-4f7c-9d0a-1f2e3d4c5b6a"
+                },
                 "result": {}
             }
         },
@@ -315,10 +672,44 @@ const docTemplate = `{
                     "type": "number",
                     "example": 1
                 },
+                "args": {
+                    "description": "Args are the arguments of functions, Arg1 and Arg2 are used by operators",
+                    "type": "array",
+                    "items": {
+                        "type": "number"
+                    }
+                },
+                "complex_args": {
+                    "description": "ComplexArgs are Arg1 and Arg2 or Args in the complex mode",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Complex"
+                    }
+                },
                 "id": {
                     "type": "string",
                     "example": "928b303f-cfcc-46f4-ae24-aabb72bbb7d9"
                 },
+                "lease": {
+                    "type": "string",
+                    "example": "5b1c5b4e-3c2b-4f7c-9d0a-1f2e3d4c5b6a"
+                },
+                "mode": {
+                    "description": "Mode is empty for float tasks, other tasks get their arguments as Operands or ComplexArgs",
+                    "type": "string",
+                    "example": "decimal"
+                },
+                "operands": {
+                    "description": "Operands are the decimal strings of Arg1 and Arg2 or of Args in the decimal and bigint modes",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "0.1",
+                        "0.2"
+                    ]
+                },
                 "operation": {
                     "type": "string",
                     "example": "+"
@@ -326,6 +717,10 @@ const docTemplate = `{
                 "operation_time": {
                     "type": "integer",
                     "example": 1000
+                },
+                "precision": {
+                    "type": "integer",
+                    "example": 20
                 }
             }
         }
@@ -2,115 +2,286 @@ package calc
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"go/ast"
-	"go/parser"
-	"go/token"
+	"math/big"
 	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
+	"orchestrator/internal/constValues"
 	"orchestrator/internal/handlers/models"
 )
 
 var (
-	divisionByZeroError  = fmt.Errorf("division by zero")
-	unsupportedNodeError = fmt.Errorf("unsupported node type")
+	divisionByZeroError    = errors.New("division by zero")
+	unsupportedNodeError   = errors.New("unsupported node type")
+	unexpectedTokenError   = errors.New("unexpected token")
+	unexpectedEndError     = errors.New("unexpected end of expression")
+	invalidNumberError     = errors.New("invalid number")
+	unknownFunctionError   = errors.New("unknown function")
+	argumentCountError     = errors.New("wrong number of arguments for function")
+	undefinedVariableError = errors.New("undefined variable")
+	inexactFunctionError   = errors.New("function can not be computed exactly")
+	nonIntegerError        = errors.New("value is not an integer")
+	complexFunctionError   = errors.New("function is not defined for complex numbers")
+	complexOperatorError   = errors.New("operator is not defined for complex numbers")
+	literalTooLongError    = fmt.Errorf("number is too long, at most %d digits and an exponent up to %d are allowed in the exact modes", maxLiteralDigits, maxLiteralDigits)
 )
 
-// ParseExpression parses a mathematical expression into a sequence of tasks
-func ParseExpression(expression string) ([]models.InternalTask, error) {
-	exprAst, err := parser.ParseExpr(expression)
+// maxLiteralDigits limits the digits and the exponent of numbers in the exact modes,
+// so a literal like 1e999999 is rejected instead of being expanded into a huge operand
+const maxLiteralDigits = 10000
+
+// Options control how an expression is turned into tasks
+type Options struct {
+	// Variables are the values of identifiers in the expression, they are parsed like numbers in the expression
+	Variables map[string]json.Number
+	// Mode is constValues.ModeFloat when empty
+	Mode string
+	// Precision is the number of digits after the decimal point kept in quotients of the decimal mode
+	Precision int
+}
+
+// ParseExpression parses a mathematical expression into a sequence of tasks,
+// identifiers are replaced with the values of variables or constants.
+// Errors pointing at a token of the expression are *SyntaxError
+func ParseExpression(expression string, opts Options) ([]models.InternalTask, error) {
+	root, err := parse(expression, opts.Variables)
 	if err != nil {
 		return nil, fmt.Errorf("parsing error: %w", err)
 	}
 
-	var tasks []models.InternalTask
-	_, err = processNode(exprAst, &tasks)
+	p := &processor{mode: opts.Mode, precision: opts.Precision}
+	if p.mode == constValues.ModeFloat {
+		p.mode = ""
+	}
+	value, err := p.processNode(root)
 	if err != nil {
 		return nil, err
 	}
 
-	return tasks, nil
+	// an expression without operations like "7" is computed as 0 + 7, the same way as unary minus
+	if len(p.tasks) == 0 {
+		p.createTask(p.zero(), value, "+")
+	}
+
+	return p.tasks, nil
+}
+
+// processor creates the tasks of an expression, the mode is empty for float expressions
+type processor struct {
+	tasks     []models.InternalTask
+	mode      string
+	precision int
 }
 
-// processNode recursively processes AST nodes and creates tasks
-func processNode(node ast.Node, tasks *[]models.InternalTask) (interface{}, error) {
-	switch n := node.(type) {
-	case *ast.BinaryExpr:
-		return processBinaryExpr(n, tasks)
-	case *ast.UnaryExpr:
-		return processUnaryExpr(n, tasks)
-	case *ast.BasicLit:
-		return processBasicLit(n)
-	case *ast.ParenExpr:
-		return processNode(n.X, tasks)
+// processNode recursively processes syntax tree nodes and creates tasks,
+// the returned value is a known argument or the ID of the task computing it
+func (p *processor) processNode(n node) (interface{}, error) {
+	switch n := n.(type) {
+	case *binaryNode:
+		return p.processBinaryNode(n)
+	case *unaryNode:
+		return p.processUnaryNode(n)
+	case *callNode:
+		return p.processCallNode(n)
+	case *numberNode:
+		return p.literal(n.token)
+	case *identNode:
+		return nil, newSyntaxError(undefinedVariableError, n.token)
 	default:
-		return nil, unsupportedNodeError
+		return nil, newSyntaxError(unsupportedNodeError, n.position())
 	}
 }
 
-func processBinaryExpr(expr *ast.BinaryExpr, tasks *[]models.InternalTask) (interface{}, error) {
-	left, err := processNode(expr.X, tasks)
+func (p *processor) processBinaryNode(n *binaryNode) (interface{}, error) {
+	left, err := p.processNode(n.left)
 	if err != nil {
 		return nil, err
 	}
 
-	right, err := processNode(expr.Y, tasks)
+	right, err := p.processNode(n.right)
 	if err != nil {
 		return nil, err
 	}
 
 	// Check for division by zero with literal values
-	if expr.Op == token.QUO {
-		if rval, ok := right.(float64); ok && rval == 0 {
-			return nil, divisionByZeroError
-		}
+	division := n.op.text == "/" || n.op.text == "%" || n.op.text == "//"
+	if division && isZero(right) {
+		return nil, newSyntaxError(divisionByZeroError, n.op)
+	}
+	// complex numbers have no order, so the remainder and the rounded quotient are not defined
+	if p.mode == constValues.ModeComplex && division && n.op.text != "/" {
+		return nil, newSyntaxError(complexOperatorError, n.op)
 	}
 
-	return createTask(tasks, left, right, expr.Op.String())
+	return p.createTask(left, right, n.op.text), nil
 }
 
-func processUnaryExpr(expr *ast.UnaryExpr, tasks *[]models.InternalTask) (interface{}, error) {
-	if expr.Op != token.SUB {
-		return nil, fmt.Errorf("unsupported unary operator: %v", expr.Op)
-	}
-
-	operand, err := processNode(expr.X, tasks)
-	if err != nil {
-		return nil, err
+func (p *processor) processUnaryNode(n *unaryNode) (interface{}, error) {
+	operand, err := p.processNode(n.operand)
+	if err != nil || n.op.text == "+" {
+		return operand, err
 	}
 
-	return createTask(tasks, 0.0, operand, token.SUB.String())
+	return p.createTask(p.zero(), operand, "-"), nil
 }
 
-func processBasicLit(lit *ast.BasicLit) (float64, error) {
-	switch lit.Kind {
-	case token.INT, token.FLOAT:
-		value, err := strconv.ParseFloat(lit.Value, 64)
+func (p *processor) processCallNode(n *callNode) (interface{}, error) {
+	f, ok := functions[n.name.text]
+	if !ok {
+		return nil, newSyntaxError(unknownFunctionError, n.name)
+	}
+	if !f.accepts(len(n.args)) {
+		return nil, newSyntaxError(argumentCountError, n.name)
+	}
+	if err := f.check(p.mode); err != nil {
+		return nil, newSyntaxError(err, n.name)
+	}
+
+	args := make([]interface{}, 0, len(n.args))
+	for _, arg := range n.args {
+		value, err := p.processNode(arg)
 		if err != nil {
-			return 0, fmt.Errorf("invalid number: %w", err)
+			return nil, err
 		}
-		return value, nil
-	default:
-		return 0, fmt.Errorf("unsupported literal type: %v", lit.Kind)
+		args = append(args, value)
+	}
+
+	taskID := uuid.New().String()
+	for _, arg := range args {
+		setParent(p.tasks, arg, taskID)
 	}
+	p.tasks = append(p.tasks, p.newTask(taskID, n.name.text, func(task *models.InternalTask) {
+		task.Args = args
+	}))
+	return taskID, nil
 }
 
-func createTask(tasks *[]models.InternalTask, left, right interface{}, operation string) (string, error) {
+func (p *processor) createTask(left, right interface{}, operation string) string {
 	taskID := uuid.New().String()
-	*tasks = append(*tasks, models.InternalTask{
-		ID:        taskID,
-		Arg1:      left,
-		Arg2:      right,
+	setParent(p.tasks, left, taskID)
+	setParent(p.tasks, right, taskID)
+	p.tasks = append(p.tasks, p.newTask(taskID, operation, func(task *models.InternalTask) {
+		task.Arg1, task.Arg2 = left, right
+	}))
+	return taskID
+}
+
+// newTask returns a task waiting for dispatch with the arguments set by fn
+func (p *processor) newTask(id, operation string, fn func(task *models.InternalTask)) models.InternalTask {
+	task := models.InternalTask{
+		ID:        id,
+		Mode:      p.mode,
 		Operation: operation,
 		Result:    "",
-	})
-	return taskID, nil
+	}
+	if p.mode == constValues.ModeDecimal {
+		task.Precision = p.precision
+	}
+	fn(&task)
+	return task
+}
+
+// literal returns the value of a number or of a substituted identifier,
+// decimal values keep the digits as written in the expression and bigint values are integers in any notation.
+// Only the float and complex modes round the value to float64. Imaginary numbers are only valid in the complex mode
+func (p *processor) literal(t token) (interface{}, error) {
+	if t.kind == tokenImag && p.mode != constValues.ModeComplex {
+		return nil, newSyntaxError(invalidNumberError, t)
+	}
+
+	switch p.mode {
+	case "", constValues.ModeComplex:
+		value, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, newSyntaxError(invalidNumberError, t)
+		}
+		if p.mode == "" {
+			return value, nil
+		}
+		if t.kind == tokenImag {
+			return models.Exact{Value: FormatComplex(complex(0, value))}, nil
+		}
+		return models.Exact{Value: FormatComplex(complex(value, 0))}, nil
+	}
+
+	if literalTooLong(t.value) {
+		return nil, newSyntaxError(literalTooLongError, t)
+	}
+	r, ok := new(big.Rat).SetString(t.value)
+	if !ok {
+		return nil, newSyntaxError(invalidNumberError, t)
+	}
+	if p.mode != constValues.ModeBigint {
+		return models.Exact{Value: t.value}, nil
+	}
+	if !r.IsInt() {
+		return nil, newSyntaxError(nonIntegerError, t)
+	}
+	return models.Exact{Value: r.Num().String()}, nil
+}
+
+// literalTooLong reports whether the mantissa or the exponent of the number exceeds maxLiteralDigits,
+// the exponent is checked before big.Rat expands it
+func literalTooLong(value string) bool {
+	mantissa, exponent, found := strings.Cut(strings.ToLower(value), "e")
+	if len(strings.Replace(mantissa, ".", "", 1)) > maxLiteralDigits {
+		return true
+	}
+	if !found {
+		return false
+	}
+	exp, err := strconv.Atoi(exponent)
+	return err != nil || exp > maxLiteralDigits || exp < -maxLiteralDigits
+}
+
+func (p *processor) zero() interface{} {
+	switch p.mode {
+	case "":
+		return 0.0
+	case constValues.ModeComplex:
+		return models.Exact{Value: FormatComplex(0)}
+	default:
+		return models.Exact{Value: "0"}
+	}
+}
+
+// isZero reports whether the argument is a known zero
+func isZero(arg interface{}) bool {
+	if value, ok := arg.(float64); ok {
+		return value == 0
+	}
+	value, ok := models.ExactValue(arg)
+	if !ok {
+		return false
+	}
+	if r, ok := new(big.Rat).SetString(value); ok {
+		return r.Sign() == 0
+	}
+	c, err := strconv.ParseComplex(value, 128)
+	return err == nil && c == 0
+}
+
+// setParent links the task referenced by arg to its parent task
+func setParent(tasks []models.InternalTask, arg interface{}, parentID string) {
+	childID, ok := arg.(string)
+	if !ok {
+		return
+	}
+
+	for i := len(tasks) - 1; i >= 0; i-- {
+		if tasks[i].ID == childID {
+			tasks[i].Parent = parentID
+			return
+		}
+	}
 }
 
 // GetTasksJSON returns tasks as JSON string
 func GetTasksJSON(expression string) (string, error) {
-	tasks, err := ParseExpression(expression)
+	tasks, err := ParseExpression(expression, Options{})
 	if err != nil {
 		return "", fmt.Errorf("error parsing expression: %w", err)
 	}
@@ -3,7 +3,10 @@ package calc
 import (
 	"encoding/json"
 	"errors"
+	"math"
+	"orchestrator/internal/constValues"
 	"orchestrator/internal/handlers/models"
+	"strings"
 	"testing"
 )
 
@@ -50,6 +53,152 @@ func TestParseExpression(t *testing.T) {
 				assertTask(t, tasks[1], "+", tasks[0].ID, 3.0)
 			},
 		},
+		{
+			name:       "parent links",
+			expression: "(1 + 2) * (3 - 4)",
+			wantTasks:  3,
+			checkResult: func(t *testing.T, tasks []models.InternalTask) {
+				if tasks[0].Parent != tasks[2].ID || tasks[1].Parent != tasks[2].ID {
+					t.Errorf("expected tasks to reference parent %q, got %q and %q", tasks[2].ID, tasks[0].Parent, tasks[1].Parent)
+				}
+				if tasks[2].Parent != "" {
+					t.Errorf("expected root task without parent, got %q", tasks[2].Parent)
+				}
+			},
+		},
+		{
+			name:       "power precedence",
+			expression: "2 * 3 ^ 2",
+			wantTasks:  2,
+			checkResult: func(t *testing.T, tasks []models.InternalTask) {
+				assertTask(t, tasks[0], "^", 3.0, 2.0)
+				assertTask(t, tasks[1], "*", 2.0, tasks[0].ID)
+			},
+		},
+		{
+			name:       "power right associativity",
+			expression: "2 ** 3 ** 2",
+			wantTasks:  2,
+			checkResult: func(t *testing.T, tasks []models.InternalTask) {
+				assertTask(t, tasks[0], "^", 3.0, 2.0)
+				assertTask(t, tasks[1], "^", 2.0, tasks[0].ID)
+			},
+		},
+		{
+			name:       "unary minus before power",
+			expression: "-2 ^ -1",
+			wantTasks:  3,
+			checkResult: func(t *testing.T, tasks []models.InternalTask) {
+				assertTask(t, tasks[0], "-", 0.0, 1.0)
+				assertTask(t, tasks[1], "^", 2.0, tasks[0].ID)
+				assertTask(t, tasks[2], "-", 0.0, tasks[1].ID)
+			},
+		},
+		{
+			name:       "separated power operator",
+			expression: "2 * * 3",
+			wantTasks:  0,
+			wantErr:    errors.New("parsing error"),
+		},
+		{
+			name:       "single number",
+			expression: "7",
+			wantTasks:  1,
+			checkResult: func(t *testing.T, tasks []models.InternalTask) {
+				assertTask(t, tasks[0], "+", 0.0, 7.0)
+			},
+		},
+		{
+			name:       "decimal comma and exponent",
+			expression: "1,5 * 2e-3",
+			wantTasks:  1,
+			checkResult: func(t *testing.T, tasks []models.InternalTask) {
+				assertTask(t, tasks[0], "*", 1.5, 0.002)
+			},
+		},
+		{
+			name:       "hexadecimal literal",
+			expression: "0x1F + 1",
+			wantTasks:  0,
+			wantErr:    invalidNumberError,
+		},
+		{
+			name:       "digit separators",
+			expression: "1_000",
+			wantTasks:  0,
+			wantErr:    invalidNumberError,
+		},
+		{
+			name:       "bitwise operator",
+			expression: "1 << 2",
+			wantTasks:  0,
+			wantErr:    unexpectedTokenError,
+		},
+		{
+			name:       "unclosed parenthesis",
+			expression: "(1 + 2",
+			wantTasks:  0,
+			wantErr:    unexpectedEndError,
+		},
+		{
+			name:       "modulo and integer division",
+			expression: "7 % 3 + 7 // 2 * 2 div 1",
+			wantTasks:  5,
+			checkResult: func(t *testing.T, tasks []models.InternalTask) {
+				assertTask(t, tasks[0], "%", 7.0, 3.0)
+				assertTask(t, tasks[1], "//", 7.0, 2.0)
+				assertTask(t, tasks[2], "*", tasks[1].ID, 2.0)
+				assertTask(t, tasks[3], "//", tasks[2].ID, 1.0)
+				assertTask(t, tasks[4], "+", tasks[0].ID, tasks[3].ID)
+			},
+		},
+		{
+			name:       "modulo by zero",
+			expression: "5 % 0",
+			wantTasks:  0,
+			wantErr:    divisionByZeroError,
+		},
+		{
+			name:       "integer division by zero",
+			expression: "5 div 0",
+			wantTasks:  0,
+			wantErr:    divisionByZeroError,
+		},
+		{
+			name:       "functions",
+			expression: "max(1, sqrt(16), 2 * 3) + pow(2,3)",
+			wantTasks:  5,
+			checkResult: func(t *testing.T, tasks []models.InternalTask) {
+				assertArgs(t, tasks[0], "sqrt", 16.0)
+				assertTask(t, tasks[1], "*", 2.0, 3.0)
+				assertArgs(t, tasks[2], "max", 1.0, tasks[0].ID, tasks[1].ID)
+				assertArgs(t, tasks[3], "pow", 2.0, 3.0)
+				assertTask(t, tasks[4], "+", tasks[2].ID, tasks[3].ID)
+				if tasks[0].Parent != tasks[2].ID || tasks[1].Parent != tasks[2].ID {
+					t.Errorf("expected arguments to reference function task %q", tasks[2].ID)
+				}
+			},
+		},
+		{
+			name:       "decimal comma outside of function calls",
+			expression: "min((1,5), 2,5)",
+			wantTasks:  1,
+			checkResult: func(t *testing.T, tasks []models.InternalTask) {
+				assertArgs(t, tasks[0], "min", 1.5, 2.0, 5.0)
+			},
+		},
+		{
+			name:       "unknown function",
+			expression: "foo(1)",
+			wantTasks:  0,
+			wantErr:    unknownFunctionError,
+		},
+		{
+			name:       "wrong number of arguments",
+			expression: "sqrt(1, 2)",
+			wantTasks:  0,
+			wantErr:    argumentCountError,
+		},
 		{
 			name:       "division by zero",
 			expression: "5 / 0",
@@ -57,10 +206,10 @@ func TestParseExpression(t *testing.T) {
 			wantErr:    divisionByZeroError,
 		},
 		{
-			name:       "invalid character",
+			name:       "undefined variable",
 			expression: "2 + a",
 			wantTasks:  0,
-			wantErr:    errors.New("unsupported node type"),
+			wantErr:    undefinedVariableError,
 		},
 		{
 			name:       "floating point numbers",
@@ -85,7 +234,7 @@ func TestParseExpression(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tasks, err := ParseExpression(tt.expression)
+			tasks, err := ParseExpression(tt.expression, Options{})
 
 			if tt.wantErr != nil {
 				if err == nil {
@@ -112,6 +261,295 @@ func TestParseExpression(t *testing.T) {
 	}
 }
 
+func TestParseExpressionVariables(t *testing.T) {
+	tasks, err := ParseExpression("x * 2 + max(x, y)", Options{Variables: map[string]json.Number{"x": "1.5", "y": "-1"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(tasks) != 3 {
+		t.Fatalf("expected 3 tasks, got %d", len(tasks))
+	}
+	assertTask(t, tasks[0], "*", 1.5, 2.0)
+	assertArgs(t, tasks[1], "max", 1.5, -1.0)
+	assertTask(t, tasks[2], "+", tasks[0].ID, tasks[1].ID)
+}
+
+func TestParseExpressionConstants(t *testing.T) {
+	tasks, err := ParseExpression("pi * r ^ 2 + e", Options{Variables: map[string]json.Number{"r": "2"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertTask(t, tasks[0], "^", 2.0, 2.0)
+	assertTask(t, tasks[1], "*", math.Pi, tasks[0].ID)
+	assertTask(t, tasks[2], "+", tasks[1].ID, math.E)
+
+	// variables take precedence over constants
+	tasks, err = ParseExpression("e + 1", Options{Variables: map[string]json.Number{"e": "5"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertTask(t, tasks[0], "+", 5.0, 1.0)
+}
+
+func TestParseExpressionDecimal(t *testing.T) {
+	opts := Options{Variables: map[string]json.Number{"x": "0.1"}, Mode: constValues.ModeDecimal, Precision: 10}
+	tasks, err := ParseExpression("0,1 + 2e-1 * -x + abs(pi)", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(tasks) != 5 {
+		t.Fatalf("expected 5 tasks, got %d", len(tasks))
+	}
+	for _, task := range tasks {
+		if task.Mode != constValues.ModeDecimal || task.Precision != 10 {
+			t.Errorf("expected decimal task with precision 10, got %q with %d", task.Mode, task.Precision)
+		}
+	}
+	assertTask(t, tasks[0], "-", models.Exact{Value: "0"}, models.Exact{Value: "0.1"})
+	assertTask(t, tasks[1], "*", models.Exact{Value: "2e-1"}, tasks[0].ID)
+	assertTask(t, tasks[2], "+", models.Exact{Value: "0.1"}, tasks[1].ID)
+	assertArgs(t, tasks[3], "abs", models.Exact{Value: "3.141592653589793"})
+
+	tasks, err = ParseExpression("7", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertTask(t, tasks[0], "+", models.Exact{Value: "0"}, models.Exact{Value: "7"})
+
+	if _, err := ParseExpression("1 % 0.00", opts); !errors.Is(err, divisionByZeroError) {
+		t.Errorf("expected division by zero, got %v", err)
+	}
+	if _, err := ParseExpression("ln(2)", opts); !errors.Is(err, inexactFunctionError) {
+		t.Errorf("expected inexact function, got %v", err)
+	}
+
+	// float tasks keep the format of older versions
+	tasks, err = ParseExpression("0.1 + 0.2", Options{Mode: constValues.ModeFloat})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tasks[0].Mode != "" {
+		t.Errorf("expected float task without mode, got %q", tasks[0].Mode)
+	}
+	assertTask(t, tasks[0], "+", 0.1, 0.2)
+}
+
+func TestParseExpressionBigint(t *testing.T) {
+	opts := Options{Variables: map[string]json.Number{"n": "1e20", "x": "0.5"}, Mode: constValues.ModeBigint}
+	tasks, err := ParseExpression("12345678901234567890123 * n + 1.5e3", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertTask(t, tasks[0], "*", models.Exact{Value: "12345678901234567890123"}, models.Exact{Value: "100000000000000000000"})
+	assertTask(t, tasks[1], "+", tasks[0].ID, models.Exact{Value: "1500"})
+	if tasks[0].Mode != constValues.ModeBigint || tasks[0].Precision != 0 {
+		t.Errorf("expected bigint task without precision, got %q with %d", tasks[0].Mode, tasks[0].Precision)
+	}
+
+	for _, expression := range []string{"1.5 + 1", "2 * x", "pi"} {
+		if _, err := ParseExpression(expression, opts); !errors.Is(err, nonIntegerError) {
+			t.Errorf("%s: expected non-integer error, got %v", expression, err)
+		}
+	}
+	if _, err := ParseExpression("cos(0)", opts); !errors.Is(err, inexactFunctionError) {
+		t.Errorf("expected inexact function, got %v", err)
+	}
+}
+
+func TestParseExpressionLargeLiterals(t *testing.T) {
+	// 2^64 + 1 is rounded by float64 and 1e400 is beyond its range, the exact modes keep them as written
+	bigTen := "1" + strings.Repeat("0", 400)
+	tasks, err := ParseExpression("18446744073709551617 + 1e400", Options{Mode: constValues.ModeBigint})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertTask(t, tasks[0], "+", models.Exact{Value: "18446744073709551617"}, models.Exact{Value: bigTen})
+
+	tasks, err = ParseExpression("18446744073709551617,5 * 1e400", Options{Mode: constValues.ModeDecimal, Precision: 20})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertTask(t, tasks[0], "*", models.Exact{Value: "18446744073709551617.5"}, models.Exact{Value: "1e400"})
+
+	for _, mode := range []string{"", constValues.ModeComplex} {
+		if _, err := ParseExpression("1e400 + 1", Options{Mode: mode}); !errors.Is(err, invalidNumberError) {
+			t.Errorf("%q: expected invalid number, got %v", mode, err)
+		}
+	}
+
+	// the exact modes reject literals that would expand into huge operands before expanding them
+	tooLong := []string{"1e10001", "1e-10001", "1e99999999999999999999", "1" + strings.Repeat("0", 10001), "0." + strings.Repeat("1", 10001)}
+	for _, mode := range []string{constValues.ModeDecimal, constValues.ModeBigint} {
+		for _, literal := range tooLong {
+			if _, err := ParseExpression(literal+" + 1", Options{Mode: mode, Precision: 20}); !errors.Is(err, literalTooLongError) {
+				t.Errorf("%s %.20s: expected too long number, got %v", mode, literal, err)
+			}
+		}
+		opts := Options{Variables: map[string]json.Number{"x": "1e999999"}, Mode: mode, Precision: 20}
+		if _, err := ParseExpression("x + 1", opts); !errors.Is(err, literalTooLongError) {
+			t.Errorf("%s: expected too long variable, got %v", mode, err)
+		}
+		if _, err := ParseExpression("1e10000 + 1", Options{Mode: mode, Precision: 20}); err != nil {
+			t.Errorf("%s: unexpected error: %v", mode, err)
+		}
+	}
+}
+
+func TestParseExpressionComplex(t *testing.T) {
+	opts := Options{Variables: map[string]json.Number{"x": "2"}, Mode: constValues.ModeComplex}
+	tasks, err := ParseExpression("(1+2i)*(3-4,5i) / x", opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(tasks) != 4 {
+		t.Fatalf("expected 4 tasks, got %d", len(tasks))
+	}
+	assertTask(t, tasks[0], "+", models.Exact{Value: "(1+0i)"}, models.Exact{Value: "(0+2i)"})
+	assertTask(t, tasks[1], "-", models.Exact{Value: "(3+0i)"}, models.Exact{Value: "(0+4.5i)"})
+	assertTask(t, tasks[2], "*", tasks[0].ID, tasks[1].ID)
+	assertTask(t, tasks[3], "/", tasks[2].ID, models.Exact{Value: "(2+0i)"})
+
+	if _, err := ParseExpression("1i / 0", opts); !errors.Is(err, divisionByZeroError) {
+		t.Errorf("expected division by zero, got %v", err)
+	}
+	if _, err := ParseExpression("max(1i, 2)", opts); !errors.Is(err, complexFunctionError) {
+		t.Errorf("expected complex function error, got %v", err)
+	}
+	if _, err := ParseExpression("3i % 2", opts); !errors.Is(err, complexOperatorError) {
+		t.Errorf("expected complex operator error, got %v", err)
+	}
+
+	// imaginary numbers are invalid in the other modes
+	for _, mode := range []string{"", constValues.ModeDecimal, constValues.ModeBigint} {
+		if _, err := ParseExpression("1 + 2i", Options{Mode: mode}); !errors.Is(err, invalidNumberError) {
+			t.Errorf("%q: expected invalid number, got %v", mode, err)
+		}
+	}
+}
+
+func TestValidResult(t *testing.T) {
+	tests := map[string]bool{
+		"0.3":   true,
+		"-12":   true,
+		"1e-30": true,
+		"1/3":   false,
+		"NaN":   false,
+		"":      false,
+	}
+
+	for value, want := range tests {
+		if got := ValidResult(constValues.ModeDecimal, value); got != want {
+			t.Errorf("ValidResult(%q) = %v, want %v", value, got, want)
+		}
+	}
+	for value, want := range map[string]bool{"123456789012345678901234567890": true, "-5": true, "0.5": false, "1e3": false} {
+		if got := ValidResult(constValues.ModeBigint, value); got != want {
+			t.Errorf("ValidResult(bigint, %q) = %v, want %v", value, got, want)
+		}
+	}
+	if ValidResult(constValues.ModeFloat, "0.3") {
+		t.Error("float results are not exact values")
+	}
+}
+
+func TestDefineConstants(t *testing.T) {
+	if err := DefineConstants(map[string]float64{"g_0": 9.81}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer delete(constants, "g_0")
+
+	tasks, err := ParseExpression("2 * g_0", Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertTask(t, tasks[0], "*", 2.0, 9.81)
+
+	for _, name := range []string{"sqrt", "div", "1g", "g-0", ""} {
+		if err := DefineConstants(map[string]float64{name: 1}); err == nil {
+			t.Errorf("expected error for constant %q", name)
+		}
+	}
+}
+
+func TestScientificNotation(t *testing.T) {
+	valid := map[string]float64{
+		"1.5e-3": 0.0015,
+		"1E3":    1000,
+		"2e+2":   200,
+		".5e1":   5,
+		"3,5e2":  350,
+	}
+	for expression, want := range valid {
+		tasks, err := ParseExpression(expression, Options{})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", expression, err)
+			continue
+		}
+		assertTask(t, tasks[0], "+", 0.0, want)
+	}
+
+	for _, expression := range []string{"2e", "1e-", "1e3.5", "1.5e-3e2", "1e+x"} {
+		if _, err := ParseExpression(expression, Options{}); !errors.Is(err, invalidNumberError) {
+			t.Errorf("%s: expected invalid number, got %v", expression, err)
+		}
+	}
+}
+
+func TestSyntaxErrorPosition(t *testing.T) {
+	tests := []struct {
+		expression string
+		token      string
+		column     int
+	}{
+		{"2 + * 3", "*", 5},
+		{"2 + 3)", ")", 6},
+		{"(2 + 3", "", 7},
+		{"1 + 'a'", "'", 5},
+		{"1.2.3 + 1", "1.2.3", 1},
+		{"√4 + x", "√", 1},
+		{"2 * (1 + x)", "x", 10},
+		{"1 + max()", "max", 5},
+		{"min(1 2)", "2", 7},
+		{"1 / 0", "/", 3},
+		{"1 + 2i", "2i", 5},
+		{"2ix", "2ix", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			_, err := ParseExpression(tt.expression, Options{})
+
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("expected syntax error, got %v", err)
+			}
+			if syntaxErr.Token != tt.token || syntaxErr.Column != tt.column {
+				t.Errorf("expected %q at column %d, got %q at column %d", tt.token, tt.column, syntaxErr.Token, syntaxErr.Column)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		" 1 + 2 ":     "1+2",
+		"1,5 * 2":     "1.5*2",
+		"7 div 2":     "7 div 2",
+		"(1 + 2) % 3": "(1+2)%3",
+		"1 + 2,5i":    "1+2.5i",
+	}
+
+	for expression, want := range tests {
+		if got := Normalize(expression); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", expression, got, want)
+		}
+	}
+}
+
 func TestGetTasksJSON(t *testing.T) {
 	expr := "2 + 3 * 4"
 	jsonStr, err := GetTasksJSON(expr)
@@ -145,6 +583,23 @@ func assertTask(t *testing.T, task models.InternalTask, op string, arg1, arg2 in
 	}
 }
 
+func assertArgs(t *testing.T, task models.InternalTask, op string, args ...interface{}) {
+	t.Helper()
+
+	if task.Operation != op {
+		t.Errorf("expected operation %q, got %q", op, task.Operation)
+	}
+
+	if len(task.Args) != len(args) {
+		t.Fatalf("expected %d args, got %d", len(args), len(task.Args))
+	}
+	for i := range args {
+		if !compareArgs(task.Args[i], args[i]) {
+			t.Errorf("arg %d mismatch: expected %v (%T), got %v (%T)", i, args[i], args[i], task.Args[i], task.Args[i])
+		}
+	}
+}
+
 func compareArgs(a, b interface{}) bool {
 	switch v1 := a.(type) {
 	case float64:
@@ -155,6 +610,10 @@ func compareArgs(a, b interface{}) bool {
 		if v2, ok := b.(string); ok {
 			return v2 == v1
 		}
+	case models.Exact:
+		if v2, ok := b.(models.Exact); ok {
+			return v2 == v1
+		}
 	}
 	return false
 }
@@ -0,0 +1,46 @@
+package calc
+
+import (
+	"fmt"
+	"math"
+	"unicode"
+)
+
+// constants are replaced with their values while parsing, variables of the request take precedence
+var constants = map[string]float64{
+	"pi":  math.Pi,
+	"e":   math.E,
+	"tau": 2 * math.Pi,
+	"phi": math.Phi,
+}
+
+// DefineConstants adds user-defined constants, it must be called before expressions are parsed.
+// Built-in constants can be redefined, names of functions and operators can not be used
+func DefineConstants(values map[string]float64) error {
+	for name := range values {
+		if !isIdentifier(name) {
+			return fmt.Errorf("invalid constant name %q", name)
+		}
+		if _, ok := functions[name]; ok {
+			return fmt.Errorf("constant %q conflicts with the function", name)
+		}
+		if _, ok := wordOperators[name]; ok {
+			return fmt.Errorf("constant %q conflicts with the operator", name)
+		}
+	}
+
+	for name, value := range values {
+		constants[name] = value
+	}
+	return nil
+}
+
+// isIdentifier reports whether the name is tokenized as a single identifier
+func isIdentifier(name string) bool {
+	for i, r := range name {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !isDigit(r)) {
+			return false
+		}
+	}
+	return name != ""
+}
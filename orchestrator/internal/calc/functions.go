@@ -0,0 +1,57 @@
+package calc
+
+import (
+	"sort"
+
+	"orchestrator/internal/constValues"
+)
+
+// function describes the number of arguments of a built-in function, maxArgs is -1 for variadic functions.
+// Only exact functions are allowed in the decimal and bigint modes, sqrt is rounded to the precision like quotients
+// in the decimal mode and must be an integer in the bigint mode. Ordered functions compare their arguments,
+// so they are not defined for complex numbers
+type function struct {
+	minArgs int
+	maxArgs int
+	exact   bool
+	ordered bool
+}
+
+// functions are computed by the agent, the name is sent as the operation of the task
+var functions = map[string]function{
+	"sqrt":  {minArgs: 1, maxArgs: 1, exact: true},
+	"abs":   {minArgs: 1, maxArgs: 1, exact: true},
+	"ln":    {minArgs: 1, maxArgs: 1},
+	"log10": {minArgs: 1, maxArgs: 1},
+	"sin":   {minArgs: 1, maxArgs: 1},
+	"cos":   {minArgs: 1, maxArgs: 1},
+	"pow":   {minArgs: 2, maxArgs: 2, exact: true},
+	"min":   {minArgs: 1, maxArgs: -1, exact: true, ordered: true},
+	"max":   {minArgs: 1, maxArgs: -1, exact: true, ordered: true},
+}
+
+// Functions returns the names of the built-in functions
+func Functions() []string {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f function) accepts(args int) bool {
+	return args >= f.minArgs && (f.maxArgs < 0 || args <= f.maxArgs)
+}
+
+// check returns an error when the function can not be computed in the mode
+func (f function) check(mode string) error {
+	switch {
+	case mode == constValues.ModeComplex && f.ordered:
+		return complexFunctionError
+	case mode != "" && mode != constValues.ModeComplex && !f.exact:
+		return inexactFunctionError
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,184 @@
+package calc
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	// tokenImag is a number with the imaginary suffix like 2i
+	tokenImag
+	tokenIdent
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	// text is the token as written in the expression
+	text string
+	// column is the position of the first character, starting from 1
+	column int
+	// value is a number as written with a dot as the decimal separator and without the imaginary suffix,
+	// or the value of a substituted identifier. It is parsed as float64 in the float and complex modes only,
+	// so the exact modes keep all digits
+	value string
+}
+
+// operators are matched longest first
+var operators = []string{"**", "//", "+", "-", "*", "/", "%", "^"}
+
+// wordOperators are identifiers that are read as operators
+var wordOperators = map[string]string{"div": "//"}
+
+// tokenize splits the expression into tokens, the last token is always tokenEOF
+func tokenize(expression string) ([]token, error) {
+	runes := []rune(expression)
+	var tokens []token
+	// calls tracks open parentheses, true for the ones of function calls where a comma separates arguments
+	var calls []bool
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		column := i + 1
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case isDigit(r) || r == '.':
+			end, imag, ok := scanNumber(runes, i, len(calls) == 0 || !calls[len(calls)-1])
+			text := string(runes[i:end])
+			kind, digits := tokenNumber, text
+			if imag {
+				kind, digits = tokenImag, strings.TrimSuffix(text, "i")
+			}
+			if !ok {
+				return nil, &SyntaxError{Err: invalidNumberError, Token: text, Column: column}
+			}
+			// a comma is accepted as the decimal separator outside of function calls
+			value := strings.Replace(digits, ",", ".", 1)
+			tokens = append(tokens, token{kind: kind, text: text, column: column, value: value})
+			i = end
+		case unicode.IsLetter(r) || r == '_':
+			end := i + 1
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || isDigit(runes[end]) || runes[end] == '_') {
+				end++
+			}
+			kind := tokenIdent
+			if _, ok := wordOperators[string(runes[i:end])]; ok {
+				kind = tokenOperator
+			}
+			tokens = append(tokens, token{kind: kind, text: string(runes[i:end]), column: column})
+			i = end
+		case r == '(':
+			calls = append(calls, len(tokens) > 0 && tokens[len(tokens)-1].kind == tokenIdent)
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", column: column})
+			i++
+		case r == ')':
+			if len(calls) > 0 {
+				calls = calls[:len(calls)-1]
+			}
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", column: column})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", column: column})
+			i++
+		default:
+			op := matchOperator(runes[i:])
+			if op == "" {
+				return nil, &SyntaxError{Err: unexpectedTokenError, Token: string(r), Column: column}
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op, column: column})
+			i += len([]rune(op))
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, column: len(runes) + 1}), nil
+}
+
+// scanNumber returns the end of the number starting at i: digits with an optional fraction
+// after a dot or a comma, an optional exponent like e-3 and an optional imaginary suffix i.
+// The number is not valid when letters or digits are stuck to it like in 0x1F or 1_000
+func scanNumber(runes []rune, i int, decimalComma bool) (int, bool, bool) {
+	i = skipDigits(runes, i)
+	if i < len(runes) && (runes[i] == '.' || decimalComma && runes[i] == ',' && i+1 < len(runes) && isDigit(runes[i+1])) {
+		i = skipDigits(runes, i+1)
+	}
+
+	if i < len(runes) && (runes[i] == 'e' || runes[i] == 'E') {
+		end := i + 1
+		if end < len(runes) && (runes[end] == '+' || runes[end] == '-') {
+			end++
+		}
+		// the exponent is a part of the number only when it has digits
+		if end < len(runes) && isDigit(runes[end]) {
+			i = skipDigits(runes, end)
+		}
+	}
+
+	imag := i < len(runes) && runes[i] == 'i'
+	if imag {
+		i++
+	}
+
+	end := i
+	for end < len(runes) && (unicode.IsLetter(runes[end]) || isDigit(runes[end]) || runes[end] == '_' || runes[end] == '.') {
+		end++
+	}
+	return end, imag, end == i
+}
+
+func skipDigits(runes []rune, i int) int {
+	for i < len(runes) && isDigit(runes[i]) {
+		i++
+	}
+	return i
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func matchOperator(runes []rune) string {
+	prefix := string(runes[:min(len(runes), 2)])
+	for _, op := range operators {
+		if strings.HasPrefix(prefix, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// Normalize returns the expression without insignificant whitespace and with dots as decimal separators,
+// so equal expressions are deduplicated. Expressions that can not be tokenized are returned unchanged
+func Normalize(expression string) string {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return expression
+	}
+
+	var b strings.Builder
+	for i, t := range tokens {
+		// words are kept apart like in "7 div 2"
+		if i > 0 && isWord(t) && isWord(tokens[i-1]) {
+			b.WriteByte(' ')
+		}
+		if t.kind == tokenNumber || t.kind == tokenImag {
+			b.WriteString(strings.Replace(t.text, ",", ".", 1))
+		} else {
+			b.WriteString(t.text)
+		}
+	}
+	return b.String()
+}
+
+func isWord(t token) bool {
+	_, ok := wordOperators[t.text]
+	return t.kind == tokenNumber || t.kind == tokenImag || t.kind == tokenIdent || t.kind == tokenOperator && ok
+}
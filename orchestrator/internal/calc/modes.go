@@ -0,0 +1,29 @@
+package calc
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	"orchestrator/internal/constValues"
+)
+
+// FormatComplex returns the value of a complex argument like (1+2i)
+func FormatComplex(c complex128) string {
+	return strconv.FormatComplex(c, 'g', -1, 128)
+}
+
+// ValidResult reports whether the result sent by the agent is a value of the exact mode
+func ValidResult(mode, value string) bool {
+	switch mode {
+	case constValues.ModeDecimal:
+		// big.Rat also accepts fractions like 1/3, the agent sends decimal strings only
+		_, ok := new(big.Rat).SetString(value)
+		return ok && !strings.Contains(value, "/")
+	case constValues.ModeBigint:
+		_, ok := new(big.Int).SetString(value, 10)
+		return ok
+	default:
+		return false
+	}
+}
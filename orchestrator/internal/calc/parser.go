@@ -0,0 +1,254 @@
+package calc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// node is an element of the syntax tree of an expression
+type node interface {
+	// position returns the token that is reported in errors about the node
+	position() token
+}
+
+type numberNode struct {
+	token token
+}
+
+type identNode struct {
+	token token
+}
+
+type unaryNode struct {
+	op      token
+	operand node
+}
+
+type binaryNode struct {
+	op          token
+	left, right node
+}
+
+type callNode struct {
+	name token
+	args []node
+}
+
+func (n *numberNode) position() token { return n.token }
+func (n *identNode) position() token  { return n.token }
+func (n *unaryNode) position() token  { return n.op }
+func (n *binaryNode) position() token { return n.op }
+func (n *callNode) position() token   { return n.name }
+
+// SyntaxError points at the token of the expression that can not be parsed or computed
+type SyntaxError struct {
+	Err error
+	// Token is the offending token as written in the expression, empty at the end of the expression
+	Token string
+	// Column is the position of the token, starting from 1
+	Column int
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%v at column %d", e.Err, e.Column)
+	}
+	return fmt.Sprintf("%v %q at column %d", e.Err, e.Token, e.Column)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+func newSyntaxError(err error, t token) *SyntaxError {
+	if t.kind == tokenEOF {
+		err = unexpectedEndError
+	}
+	return &SyntaxError{Err: err, Token: t.text, Column: t.column}
+}
+
+// parser builds the syntax tree of an expression with the precedence of arithmetic:
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/" | "%" | "//" | "div") unary }
+//	unary   = ("-" | "+") unary | power
+//	power   = primary [ ("^" | "**") unary ]
+//	primary = number | imaginary | identifier | call | "(" expr ")"
+//	call    = identifier "(" [ expr { "," expr } ] ")"
+type parser struct {
+	tokens    []token
+	next      int
+	variables map[string]json.Number
+}
+
+// parse reads the whole expression, identifiers of the variables are replaced with their values
+func parse(expression string, variables map[string]json.Number) (node, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, variables: variables}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, newSyntaxError(unexpectedTokenError, p.peek())
+	}
+	return root, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+// isOperator reports whether the next token is one of the operators
+func (p *parser) isOperator(ops ...string) bool {
+	t := p.peek()
+	if t.kind != tokenOperator {
+		return false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) parseExpr() (node, error) {
+	return p.parseBinary(p.parseTerm, "+", "-")
+}
+
+func (p *parser) parseTerm() (node, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "%", "//", "div")
+}
+
+// parseBinary reads a left associative chain of operands joined by the operators
+func (p *parser) parseBinary(operand func() (node, error), ops ...string) (node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator(ops...) {
+		op := p.advance()
+		// word operators are sent to the agent as their symbols
+		if symbol, ok := wordOperators[op.text]; ok {
+			op.text = symbol
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseUnary reads a signed operand, the sign applies to the whole power so -2^2 is -4
+func (p *parser) parseUnary() (node, error) {
+	if p.isOperator("-", "+") {
+		op := p.advance()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePower()
+}
+
+// parsePower reads a right associative power, the exponent may be signed like in 2^-1
+func (p *parser) parsePower() (node, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.isOperator("^", "**") {
+		return base, nil
+	}
+	op := p.advance()
+	// ** is an alias, both are sent to the agent as ^
+	op.text = "^"
+	exponent, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &binaryNode{op: op, left: base, right: exponent}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenNumber, tokenImag:
+		p.advance()
+		return &numberNode{token: t}, nil
+	case tokenIdent:
+		p.advance()
+		if p.peek().kind == tokenLParen {
+			return p.parseCall(t)
+		}
+		if value, ok := p.variables[t.text]; ok {
+			t.value = value.String()
+			return &numberNode{token: t}, nil
+		}
+		if value, ok := constants[t.text]; ok {
+			t.value = strconv.FormatFloat(value, 'g', -1, 64)
+			return &numberNode{token: t}, nil
+		}
+		return &identNode{token: t}, nil
+	case tokenLParen:
+		p.advance()
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokenRParen {
+			return nil, newSyntaxError(unexpectedTokenError, p.peek())
+		}
+		p.advance()
+		return expr, nil
+	default:
+		return nil, newSyntaxError(unexpectedTokenError, t)
+	}
+}
+
+// parseCall reads the arguments of a function call, the name is already read
+func (p *parser) parseCall(name token) (node, error) {
+	p.advance()
+	call := &callNode{name: name}
+	if p.peek().kind == tokenRParen {
+		p.advance()
+		return call, nil
+	}
+
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+
+		switch p.peek().kind {
+		case tokenComma:
+			p.advance()
+		case tokenRParen:
+			p.advance()
+			return call, nil
+		default:
+			return nil, newSyntaxError(unexpectedTokenError, p.peek())
+		}
+	}
+}
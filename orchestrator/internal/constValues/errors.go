@@ -1,11 +1,28 @@
 package constValues
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
-	NotFoundError          = errors.New("not found")
-	ContentTypeError       = errors.New("invalid content type, must be application/json")
-	InvalidJsonError       = errors.New("invalid json")
-	InvalidExpressionError = errors.New("invalid expression")
-	InvalidUuidError       = errors.New("invalid uuid")
+	NotFoundError           = errors.New("not found")
+	ContentTypeError        = errors.New("invalid content type, must be application/json")
+	InvalidJsonError        = errors.New("invalid json")
+	InvalidExpressionError  = errors.New("invalid expression")
+	InvalidUuidError        = errors.New("invalid uuid")
+	InvalidResultError      = errors.New("invalid result")
+	LeaseExpiredError       = errors.New("task lease expired")
+	InvalidQueryError       = errors.New("invalid query parameters")
+	InvalidStatusError      = errors.New("invalid status, must be one of DONE, PROCESSING, ERROR, CANCELLED")
+	InvalidCursorError      = errors.New("invalid cursor")
+	InvalidLimitError       = errors.New("invalid limit")
+	InvalidOrderError       = errors.New("invalid order, must be asc or desc")
+	ExpressionFinishedError = errors.New("expression is already finished")
+	ExpressionRunningError  = errors.New("expression is still processing")
+	InvalidPurgeStatusError = errors.New("invalid status, must be one of DONE, ERROR, CANCELLED")
+	InvalidAgeError         = errors.New("invalid age, must be a duration like 24h")
+	TaskUnavailableError    = errors.New("task is not available for dispatch")
+	InvalidModeError        = errors.New("invalid mode, must be float, decimal, bigint or complex")
+	InvalidPrecisionError   = fmt.Errorf("invalid precision, must be from 1 to %d in decimal mode", MaxPrecision)
 )
@@ -4,4 +4,33 @@ const (
 	Error      = "ERROR"
 	Processing = "PROCESSING"
 	Done       = "DONE"
+	Cancelled  = "CANCELLED"
+)
+
+const (
+	// ErrorKindFatal is a deterministic error, e.g. division by zero, the task is never retried
+	ErrorKindFatal = "FATAL"
+	// ErrorKindTransient is a temporary error, e.g. timeout, the task is retried with backoff
+	ErrorKindTransient = "TRANSIENT"
+)
+
+const (
+	ErrorCodeCalculation  = "CALCULATION_ERROR"
+	ErrorCodeLeaseExpired = "LEASE_EXPIRED"
+)
+
+const (
+	// ModeFloat computes expressions with float64 values
+	ModeFloat = "float"
+	// ModeDecimal computes expressions exactly with decimal strings, quotients are rounded to the precision
+	ModeDecimal = "decimal"
+	// ModeBigint computes expressions with integers of any size, results that are not integers are errors
+	ModeBigint = "bigint"
+	// ModeComplex computes expressions with complex128 values, imaginary numbers are written like 2i
+	ModeComplex = "complex"
+)
+
+const (
+	DefaultPrecision = 20
+	MaxPrecision     = 1000
 )
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"orchestrator/internal/constValues"
+	"orchestrator/internal/handlers/models"
+	"time"
+)
+
+// PurgeExpressions @Summary      Удалить завершённые выражения
+// @Description  Удаляет выражения со статусом DONE, ERROR или CANCELLED вместе с их задачами, выражения в процессе вычисления не удаляются
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        status     query string false "Статус выражения" Enums(DONE, ERROR, CANCELLED)
+// @Param        older_than query string false "Удалить выражения, созданные раньше, чем указанное время назад, например 24h"
+// @Success      200  {object}  models.PurgeResponse
+// @Failure      422  {object}  models.ApiError
+// @Failure      500  {object}  models.ApiError
+// @Router       /admin/expressions [delete]
+func (a *Controller) PurgeExpressions(c fiber.Ctx) error {
+	var query models.PurgeRequest
+	if err := c.Bind().Query(&query); err != nil {
+		return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidQueryError)
+	}
+
+	switch query.Status {
+	case "", constValues.Done, constValues.Error, constValues.Cancelled:
+	default:
+		return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidPurgeStatusError)
+	}
+
+	createdBefore := time.Now()
+	if query.OlderThan != "" {
+		age, err := time.ParseDuration(query.OlderThan)
+		if err != nil || age < 0 {
+			return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidAgeError)
+		}
+		createdBefore = createdBefore.Add(-age)
+	}
+
+	deleted, err := a.purgeExpressions(c.Context(), query.Status, createdBefore)
+	if err != nil {
+		return sendError(c, fiber.StatusInternalServerError, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&models.PurgeResponse{Deleted: deleted})
+}
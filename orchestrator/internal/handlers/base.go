@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-playground/validator/v10"
@@ -11,25 +10,28 @@ import (
 	corsWare "github.com/gofiber/fiber/v3/middleware/cors"
 	healthWare "github.com/gofiber/fiber/v3/middleware/healthcheck"
 	loggerWare "github.com/gofiber/fiber/v3/middleware/logger"
-	"github.com/redis/go-redis/v9"
+	"orchestrator/internal/calc"
 	"orchestrator/internal/constValues"
 	"orchestrator/internal/handlers/middlewares"
 	"orchestrator/internal/handlers/models"
 	"orchestrator/internal/logger"
+	"orchestrator/internal/storage"
 	"os"
 	"strconv"
+	"time"
 )
 
 type Controller struct {
 	app         *fiber.App
 	cfg         *Config
-	Expressions *redis.Client
-	Results     *redis.Client
-	Tasks       *redis.Client
+	Expressions storage.ExpressionStore
+	Tasks       storage.TaskStore
 	Validator   *validator.Validate
 }
 
 func (a *Controller) Start() {
+	go a.runReaper(context.Background())
+
 	err := a.app.Listen(":9090")
 	if err != nil {
 		logger.Log.Fatal(err)
@@ -54,41 +56,19 @@ func New() *Controller {
 
 	logger.Log.Info("Validator initialized")
 
-	redisAddr := os.Getenv("REDIS_ADDR")
-	if redisAddr == "" {
-		redisAddr = "localhost:6379"
-	}
-
-	logger.Log.Info("Initializing redis")
-
-	redisExpressions := redis.NewClient(&redis.Options{
-		Addr: redisAddr,
-		DB:   0,
-	})
-
-	if err := redisExpressions.Ping(context.Background()).Err(); err != nil {
-		logger.Log.Fatal("Error connecting to redis expressions")
+	cfg := newConfig()
+	if err := calc.DefineConstants(cfg.Constants); err != nil {
+		logger.Log.Fatal("Error defining constants: ", err)
 	}
 
-	redisResults := redis.NewClient(&redis.Options{
-		Addr: redisAddr,
-		DB:   1,
-	})
+	logger.Log.Info("Initializing storage")
 
-	if err := redisResults.Ping(context.Background()).Err(); err != nil {
-		logger.Log.Fatal("Error connecting to redis results")
-	}
-
-	redisTasks := redis.NewClient(&redis.Options{
-		Addr: redisAddr,
-		DB:   2,
-	})
-
-	if err := redisTasks.Ping(context.Background()).Err(); err != nil {
-		logger.Log.Fatal("Error connecting to redis tasks")
+	store, err := newStore(context.Background())
+	if err != nil {
+		logger.Log.Fatal("Error initializing storage: ", err)
 	}
 
-	logger.Log.Info("Redis initialized")
+	logger.Log.Info("Storage initialized")
 
 	// use cors
 	a.Use(corsWare.New())
@@ -108,196 +88,328 @@ func New() *Controller {
 		Title:    "Swagger API Docs",
 	}))
 
-	timeAddStr := os.Getenv("TIME_ADDITION_MS")
-	timeAdd := 1000
-	if timeAddStr != "" {
-		var err error
-		timeAdd, err = strconv.Atoi(timeAddStr)
-		if err != nil {
-			logger.Log.Fatal(err)
-		}
-	}
-	timeSubStr := os.Getenv("TIME_SUBTRACTION_MS")
-	timeSub := 1000
-	if timeSubStr != "" {
-		var err error
-		timeSub, err = strconv.Atoi(timeSubStr)
-		if err != nil {
-			logger.Log.Fatal(err)
-		}
-	}
-	timeMulStr := os.Getenv("TIME_MULTIPLICATIONS_MS")
-	timeMul := 1000
-	if timeMulStr != "" {
-		var err error
-		timeMul, err = strconv.Atoi(timeMulStr)
-		if err != nil {
-			logger.Log.Fatal(err)
-		}
-	}
-	timeDivStr := os.Getenv("TIME_DIVISIONS_MS")
-	timeDiv := 1000
-	if timeDivStr != "" {
-		var err error
-		timeDiv, err = strconv.Atoi(timeDivStr)
-		if err != nil {
-			logger.Log.Fatal(err)
-		}
-	}
-
 	// create api controller
 	h := &Controller{
-		Expressions: redisExpressions,
-		Results:     redisResults,
-		Tasks:       redisTasks,
+		Expressions: store.Expressions,
+		Tasks:       store.Tasks,
 		Validator:   newValidator,
 		app:         a,
-		cfg: &Config{
-			TimeAdditionMS:       timeAdd,
-			TimeSubtractionMS:    timeSub,
-			TimeMultiplicationMS: timeMul,
-			TimeDivisionMS:       timeDiv,
-		},
+		cfg:         cfg,
 	}
 
-	// map api routes
-	a.Post("/api/v1/calculate", h.PostExpression)
-	a.Get("/api/v1/expressions", h.ListExpressions)
-	a.Get("/api/v1/expressions/:id", h.GetById)
-	a.Get("/internal/task", h.GetTask)
-	a.Post("/internal/task", h.SetTask)
+	h.mapRoutes()
 
 	return h
 }
 
-type Config struct {
-	TimeAdditionMS       int
-	TimeSubtractionMS    int
-	TimeMultiplicationMS int
-	TimeDivisionMS       int
+// mapRoutes maps the api routes to the handlers of the controller
+func (a *Controller) mapRoutes() {
+	a.app.Post("/api/v1/calculate", a.PostExpression)
+	a.app.Get("/api/v1/expressions", a.ListExpressions)
+	a.app.Get("/api/v1/expressions/:id", a.GetById)
+	a.app.Delete("/api/v1/expressions/:id", a.CancelExpression)
+	a.app.Delete("/admin/expressions", a.PurgeExpressions)
+	a.app.Get("/internal/task", a.GetTask)
+	a.app.Post("/internal/task", a.SetTask)
 }
 
-func (c *Config) GetOperationTime(operation string) int {
-	switch operation {
-	case "+":
-		return c.TimeAdditionMS
-	case "-":
-		return c.TimeSubtractionMS
-	case "*":
-		return c.TimeMultiplicationMS
-	case "/":
-		return c.TimeDivisionMS
+// newStore creates the storage backend selected by the STORAGE variable
+func newStore(ctx context.Context) (*storage.Store, error) {
+	switch os.Getenv("STORAGE") {
+	case "", "redis":
+		return newRedisStore(ctx)
+	case "memory":
+		return storage.NewMemory(), nil
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "orchestrator.db"
+		}
+		return storage.NewSQLite(ctx, path)
+	case "postgres":
+		return storage.NewPostgres(ctx, os.Getenv("DATABASE_URL"))
+	default:
+		return nil, fmt.Errorf("unknown storage %q, must be redis, memory, sqlite or postgres", os.Getenv("STORAGE"))
 	}
-
-	return 0
 }
 
-func (a *Controller) getTask(ctx context.Context, taskId string) (*models.InternalTask, error) {
-	taskStr, err := a.Tasks.Get(ctx, taskId).Result()
-	if err != nil {
-		return nil, err
-	}
+// resolveDependency substitutes the result of a finished task into its parent
+// and puts the parent into the ready queue once all of its arguments are known,
+// errors are propagated up to the root task
+func (a *Controller) resolveDependency(ctx context.Context, task *models.InternalTask) error {
+	for task.Parent != "" {
+		var failed, ready bool
+		parent, err := a.Tasks.Modify(ctx, task.Parent, func(parent *models.InternalTask) error {
+			failed, ready = false, false
+			if parent.Result != "" {
+				// an error stored by a resolution that failed afterwards is propagated again
+				failed = parent.Result == constValues.Error
+				return nil
+			}
+
+			if task.Result == constValues.Error {
+				parent.Result = constValues.Error
+				parent.Error = task.Error
+				failed = true
+				return nil
+			}
+
+			result, err := argumentValue(task)
+			if err != nil {
+				return err
+			}
+			if parent.Arg1 == task.ID {
+				parent.Arg1 = result
+			}
+			if parent.Arg2 == task.ID {
+				parent.Arg2 = result
+			}
+			for i := range parent.Args {
+				if parent.Args[i] == task.ID {
+					parent.Args[i] = result
+				}
+			}
+
+			ready = a.getTaskResponse(parent) != nil
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if ready {
+			return a.Tasks.Enqueue(ctx, parent.ID)
+		}
+		if !failed {
+			return nil
+		}
+		if err := a.updateResult(ctx, parent); err != nil {
+			return err
+		}
 
-	var task models.InternalTask
-	if err := json.Unmarshal([]byte(taskStr), &task); err != nil {
-		return nil, err
+		task = parent
 	}
-	return &task, nil
-}
 
-func (a *Controller) processTaskArguments(ctx context.Context, taskId string, task *models.InternalTask) (bool, error) {
-	hasError := false
+	return nil
+}
 
-	if err := processArgument(ctx, a, task, &task.Arg1); err != nil {
-		return false, err
-	}
-	if task.Result == constValues.Error {
-		hasError = true
+// argumentValue returns the result of the task as an argument of its parent
+func argumentValue(task *models.InternalTask) (interface{}, error) {
+	if task.Mode != "" {
+		value, ok := task.Result.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected result type: %T", task.Result)
+		}
+		return models.Exact{Value: value}, nil
 	}
+	return convertResult(task.Result)
+}
 
-	if err := processArgument(ctx, a, task, &task.Arg2); err != nil {
-		return false, err
+func convertResult(result interface{}) (float64, error) {
+	switch v := result.(type) {
+	case string:
+		return strconv.ParseFloat(v, 64)
+	case float64:
+		return v, nil
+	default:
+		return 0, fmt.Errorf("unexpected result type: %T", v)
 	}
-	if task.Result == constValues.Error {
-		hasError = true
+}
+
+// approximate returns the float64 closest to the exact value or the real part of a complex value,
+// values beyond the range of float64 are 0
+func approximate(mode, value string) float64 {
+	if mode == constValues.ModeComplex {
+		c, err := strconv.ParseComplex(value, 128)
+		if err != nil {
+			return 0
+		}
+		return real(c)
 	}
 
-	if hasError {
-		return true, a.updateErrorTask(ctx, taskId, task)
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
 	}
+	return result
+}
 
-	return false, a.updateTask(ctx, taskId, task)
+// finishTask publishes the result of a task that will not be dispatched again
+func (a *Controller) finishTask(ctx context.Context, task *models.InternalTask) error {
+	if err := a.updateResult(ctx, task); err != nil {
+		return err
+	}
+	return a.resolveDependency(ctx, task)
 }
 
-func processArgument(ctx context.Context, a *Controller, task *models.InternalTask, arg *interface{}) error {
-	argStr, ok := (*arg).(string)
-	if !ok {
+// updateResult stores the result of a root task as the result of its expression,
+// results of intermediate tasks are skipped
+func (a *Controller) updateResult(ctx context.Context, task *models.InternalTask) error {
+	if task.ID != task.ExpressionID {
 		return nil
 	}
 
-	argTask, err := a.getTask(ctx, argStr)
-	if err != nil || argTask.Result == constValues.Processing || argTask.Result == "" {
+	record, err := a.Expressions.Modify(ctx, task.ID, func(record *models.InternalExpression) error {
+		if record.Status == constValues.Cancelled {
+			return constValues.ExpressionFinishedError
+		}
+
+		now := time.Now()
+		record.FinishedAt = &now
+
+		if task.Result == constValues.Error {
+			record.Status = constValues.Error
+			record.Error = task.Error
+			return nil
+		}
+
+		record.Status = constValues.Done
+		if task.Mode != "" {
+			value, ok := task.Result.(string)
+			if !ok {
+				return fmt.Errorf("unexpected result type: %T", task.Result)
+			}
+			record.Value = value
+			record.Result = approximate(task.Mode, value)
+			return nil
+		}
+
+		result, err := convertResult(task.Result)
+		if err != nil {
+			return err
+		}
+		record.Result = result
+		return nil
+	})
+	if errors.Is(err, constValues.NotFoundError) || errors.Is(err, constValues.ExpressionFinishedError) {
+		return nil
+	} else if err != nil {
 		return err
 	}
+	return a.finishExpression(ctx, task.ID, record)
+}
 
-	if argTask.Result == constValues.Error {
-		task.Result = constValues.Error
+// markStarted sets the start time of the expression on the first dispatch of its tasks
+func (a *Controller) markStarted(ctx context.Context, id string) error {
+	_, err := a.Expressions.Modify(ctx, id, func(record *models.InternalExpression) error {
+		if record.StartedAt == nil {
+			now := time.Now()
+			record.StartedAt = &now
+		}
+		return nil
+	})
+	if errors.Is(err, constValues.NotFoundError) {
 		return nil
 	}
+	return err
+}
 
-	result, err := convertResult(argTask.Result)
-	if err != nil {
-		return err
+// cancelTasks stops the tasks of a cancelled expression, tasks already dispatched are marked as cancelled
+// to discard their results and all other tasks, waiting or computed, are deleted
+func (a *Controller) cancelTasks(ctx context.Context, taskIds []string) error {
+	var finished []string
+	for _, taskId := range taskIds {
+		dispatched := false
+		_, err := a.Tasks.Modify(ctx, taskId, func(task *models.InternalTask) error {
+			dispatched = task.Result == constValues.Processing
+			task.Result = constValues.Cancelled
+			return nil
+		})
+		if errors.Is(err, constValues.NotFoundError) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if !dispatched {
+			finished = append(finished, taskId)
+		}
 	}
 
-	*arg = result
-	return nil
+	return a.deleteTasks(ctx, finished)
 }
 
-func convertResult(result interface{}) (float64, error) {
-	switch v := result.(type) {
-	case string:
-		return strconv.ParseFloat(v, 64)
-	case float64:
-		return v, nil
-	default:
-		return 0, fmt.Errorf("unexpected result type: %T", v)
+// newTaskError describes a failed task for the result of its expression
+func newTaskError(task *models.InternalTask, code, message string) *models.ExpressionError {
+	if code == "" {
+		code = constValues.ErrorCodeCalculation
+	}
+	if message == "" {
+		message = "calculation failed"
+	}
+
+	return &models.ExpressionError{
+		Code:      code,
+		Message:   message,
+		TaskId:    task.ID,
+		Operation: task.Operation,
 	}
 }
 
-func (a *Controller) updateErrorTask(ctx context.Context, taskId string, task *models.InternalTask) error {
-	if err := a.updateTask(ctx, taskId, task); err != nil {
-		return err
+// getTaskResponse returns nil while some arguments of the task are not computed yet
+func (a *Controller) getTaskResponse(task *models.InternalTask) *models.TaskResponse {
+	response := &models.TaskResponse{
+		ID:            task.ID,
+		Operation:     task.Operation,
+		OperationTime: a.cfg.GetOperationTime(task.Operation),
 	}
 
-	if err := a.Results.Get(ctx, task.ID).Err(); errors.Is(err, redis.Nil) {
-		return a.Results.Set(ctx, task.ID, task.Result, 0).Err()
+	if task.Mode != "" {
+		return exactTaskResponse(task, response)
 	}
-	return nil
-}
 
-func (a *Controller) updateTask(ctx context.Context, taskId string, task *models.InternalTask) error {
-	taskBytes, err := json.Marshal(task)
-	if err != nil {
-		return err
+	if task.Args != nil {
+		response.Args = make([]float64, 0, len(task.Args))
+		for _, arg := range task.Args {
+			value, ok := arg.(float64)
+			if !ok {
+				return nil
+			}
+			response.Args = append(response.Args, value)
+		}
+		return response
 	}
-	return a.Tasks.Set(ctx, taskId, string(taskBytes), 0).Err()
-}
 
-func (a *Controller) getTaskResponse(task *models.InternalTask) *models.TaskResponse {
 	arg1, ok1 := task.Arg1.(float64)
 	arg2, ok2 := task.Arg2.(float64)
 	if !ok1 || !ok2 {
 		return nil
 	}
+	response.Arg1, response.Arg2 = arg1, arg2
+	return response
+}
 
-	return &models.TaskResponse{
-		ID:            task.ID,
-		Arg1:          arg1,
-		Arg2:          arg2,
-		Operation:     task.Operation,
-		OperationTime: a.cfg.GetOperationTime(task.Operation),
+// exactTaskResponse sends the arguments of the task as Operands or ComplexArgs,
+// nil is returned while some of them are not computed yet
+func exactTaskResponse(task *models.InternalTask, response *models.TaskResponse) *models.TaskResponse {
+	args := task.Args
+	if args == nil {
+		args = []interface{}{task.Arg1, task.Arg2}
+	}
+
+	response.Mode = task.Mode
+	response.Precision = task.Precision
+	operands := make([]string, 0, len(args))
+	for _, arg := range args {
+		value, ok := models.ExactValue(arg)
+		if !ok {
+			return nil
+		}
+		operands = append(operands, value)
+	}
+
+	if task.Mode != constValues.ModeComplex {
+		response.Operands = operands
+		return response
+	}
+	for _, operand := range operands {
+		c, err := strconv.ParseComplex(operand, 128)
+		if err != nil {
+			return nil
+		}
+		response.ComplexArgs = append(response.ComplexArgs, models.Complex{Real: real(c), Imag: imag(c)})
 	}
+	return response
 }
 
 func sendError(c fiber.Ctx, status int, err error) error {
@@ -1,25 +1,29 @@
 package handlers
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/gofiber/fiber/v3"
 	"github.com/google/uuid"
-	"github.com/redis/go-redis/v9"
 	"orchestrator/internal/calc"
 	"orchestrator/internal/constValues"
 	"orchestrator/internal/handlers/models"
+	"orchestrator/internal/storage"
 	"strings"
+	"time"
 )
 
 // PostExpression @Summary      Добавить выражение в очередь на выполнение
 // @Tags         calculate
 // @Accept       json
 // @Produce      json
+// @Description  Если выражение уже вычислялось, возвращается его UUID. Чтобы вычислить его заново, передайте "force": true или заголовок Cache-Control: no-cache.
+// @Description  В режиме "mode": "decimal" выражение вычисляется точно, частные округляются до precision знаков после запятой, в режиме "bigint" - в целых числах любой длины, в режиме "complex" - в комплексных числах вида 1+2i
 // @Param        body body  models.CalculateRequest true  "Объект, содержащий в себе выражение"
+// @Param        Cache-Control header string false "no-cache, чтобы не использовать кэш"
 // @Success      200  {object}  models.CalculateResponse
 // @Success      201  {object}  models.CalculateResponse
-// @Failure      422  {object}  models.ApiError
+// @Failure      422  {object}  models.ExpressionSyntaxError
 // @Failure      500  {object}  models.ApiError
 // @Router       /api/v1/calculate [post]
 func (a *Controller) PostExpression(c fiber.Ctx) error {
@@ -32,38 +36,70 @@ func (a *Controller) PostExpression(c fiber.Ctx) error {
 		return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidJsonError)
 	}
 
-	body.Expression = strings.ReplaceAll(body.Expression, " ", "")
-	body.Expression = strings.ReplaceAll(body.Expression, ",", ".")
-
-	result, err := a.Expressions.Get(c.Context(), body.Expression).Result()
-	if err != nil && !errors.Is(err, redis.Nil) {
-		return sendError(c, fiber.StatusInternalServerError, err)
-	} else if errors.Is(err, redis.Nil) {
-		id := uuid.New().String()
-
-		tasks, err := calc.ParseExpression(body.Expression)
-		if err != nil {
-			return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidExpressionError)
+	if body.Numeric != "" {
+		if body.Mode != "" && body.Mode != body.Numeric {
+			return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidModeError)
 		}
+		body.Mode = body.Numeric
+	}
 
-		if a.Expressions.Set(c.Context(), body.Expression, id, 0).Err() != nil ||
-			a.Results.Set(c.Context(), id, constValues.Processing, 0).Err() != nil {
-			return sendError(c, fiber.StatusInternalServerError, err)
+	switch body.Mode {
+	case "", constValues.ModeFloat, constValues.ModeBigint, constValues.ModeComplex:
+		if body.Precision != 0 {
+			return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidPrecisionError)
 		}
-
-		for i, task := range tasks {
-			var taskString []byte
-			if i == len(tasks)-1 {
-				task.ID = id
-			}
-			if taskString, err = json.Marshal(task); err != nil {
-				return sendError(c, fiber.StatusInternalServerError, err)
-			}
-			if a.Tasks.Set(c.Context(), task.ID, string(taskString), 0).Err() != nil {
-				return sendError(c, fiber.StatusInternalServerError, err)
-			}
+		// float expressions are stored without the mode, like the ones of older versions
+		if body.Mode == constValues.ModeFloat {
+			body.Mode = ""
+		}
+	case constValues.ModeDecimal:
+		if body.Precision == 0 {
+			body.Precision = constValues.DefaultPrecision
+		}
+		if body.Precision < 1 || body.Precision > constValues.MaxPrecision {
+			return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidPrecisionError)
 		}
+	default:
+		return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidModeError)
+	}
+
+	tasks, err := calc.ParseExpression(body.Expression, calc.Options{
+		Variables: body.Variables,
+		Mode:      body.Mode,
+		Precision: body.Precision,
+	})
+	if err != nil {
+		return sendExpressionError(c, err)
+	}
+
+	// the expression is normalized after parsing, so error columns match the submitted text
+	body.Expression = calc.Normalize(body.Expression)
+
+	id := uuid.New().String()
+	taskIds := bindTasks(tasks, id)
+
+	createdAt := time.Now()
+	record := &models.InternalExpression{
+		Expression: body.Expression,
+		Variables:  body.Variables,
+		Mode:       body.Mode,
+		Precision:  body.Precision,
+		Status:     constValues.Processing,
+		TaskCount:  len(tasks),
+		Tasks:      taskIds,
+		CreatedAt:  &createdAt,
+	}
 
+	force := body.Force || strings.Contains(c.Get("Cache-Control"), "no-cache")
+	result, err := a.Expressions.Submit(c.Context(), id, record, tasks, storage.SubmitOptions{
+		Force:       force,
+		CacheErrors: a.cfg.CacheErrors,
+	})
+	if err != nil {
+		return sendError(c, fiber.StatusInternalServerError, err)
+	}
+
+	if result == id {
 		return c.Status(fiber.StatusCreated).JSON(models.CalculateResponse{
 			Id: id,
 		})
@@ -71,3 +107,41 @@ func (a *Controller) PostExpression(c fiber.Ctx) error {
 
 	return c.Status(fiber.StatusOK).JSON(&models.CalculateResponse{Id: result})
 }
+
+// sendExpressionError responds with the position of the offending token when the error has it
+func sendExpressionError(c fiber.Ctx, err error) error {
+	err = fmt.Errorf("%w: %w", constValues.InvalidExpressionError, err)
+
+	var syntaxErr *calc.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		return sendError(c, fiber.StatusUnprocessableEntity, err)
+	}
+	return c.Status(fiber.StatusUnprocessableEntity).JSON(&models.ExpressionSyntaxError{
+		Message: err.Error(),
+		Code:    fiber.StatusUnprocessableEntity,
+		Column:  syntaxErr.Column,
+		Token:   syntaxErr.Token,
+	})
+}
+
+// bindTasks gives the root task the ID of the expression and links all tasks to it,
+// the IDs of the tasks are returned
+func bindTasks(tasks []models.InternalTask, id string) []string {
+	rootId := ""
+	if len(tasks) > 0 {
+		rootId = tasks[len(tasks)-1].ID
+	}
+
+	taskIds := make([]string, 0, len(tasks))
+	for i := range tasks {
+		if tasks[i].ID == rootId {
+			tasks[i].ID = id
+		}
+		if tasks[i].Parent == rootId {
+			tasks[i].Parent = id
+		}
+		tasks[i].ExpressionID = id
+		taskIds = append(taskIds, tasks[i].ID)
+	}
+	return taskIds
+}
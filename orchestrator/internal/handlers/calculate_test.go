@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"orchestrator/internal/handlers/models"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalculateCache(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		id, _ := submit(t, h, "1+2")
+		cachedId, status := submit(t, h, "1 + 2")
+		require.Equal(t, fiber.StatusOK, status)
+		require.Equal(t, id, cachedId)
+
+		var resp models.CalculateResponse
+		status = doRequest(t, h, fiber.MethodPost, "/api/v1/calculate", &models.CalculateRequest{Expression: "1+2", Force: true}, &resp)
+		require.Equal(t, fiber.StatusCreated, status)
+		require.NotEqual(t, id, resp.Id)
+	})
+}
+
+func TestCalculateConcurrent(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		const submissions = 8
+		ids := make([]string, submissions)
+		var wg sync.WaitGroup
+		for i := range ids {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ids[i], _ = submit(t, h, "6*7")
+			}()
+		}
+		wg.Wait()
+
+		// concurrent submissions of the same expression share one graph of tasks
+		for _, id := range ids {
+			require.Equal(t, ids[0], id)
+		}
+		require.Equal(t, 1, solve(t, h))
+	})
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"orchestrator/internal/calc"
+	"orchestrator/internal/logger"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	TimeAdditionMS       int
+	TimeSubtractionMS    int
+	TimeMultiplicationMS int
+	TimeDivisionMS       int
+	TimePowerMS          int
+	TimeModuloMS         int
+	TimeIntDivisionMS    int
+	// TimeFunctionsMS is an operation time of each built-in function
+	TimeFunctionsMS map[string]int
+	// Constants are user-defined constants available in all expressions
+	Constants map[string]float64
+	// LeaseGraceMS is added to the operation time of a dispatched task to get its lease deadline
+	LeaseGraceMS int
+	// ReaperIntervalMS is an interval between checks for expired leases and delayed tasks
+	ReaperIntervalMS int
+	// MaxAttempts limits dispatches of a task failing with transient errors
+	MaxAttempts int
+	// RetryBackoffMS is a delay before the first retry, it doubles with every attempt
+	RetryBackoffMS int
+	// ExpressionTTL is a retention time of finished expressions, zero keeps them forever
+	ExpressionTTL time.Duration
+	// CacheErrors allows returning failed expressions for repeated submissions
+	CacheErrors bool
+}
+
+func newConfig() *Config {
+	return &Config{
+		TimeAdditionMS:       getEnvInt("TIME_ADDITION_MS", 1000),
+		TimeSubtractionMS:    getEnvInt("TIME_SUBTRACTION_MS", 1000),
+		TimeMultiplicationMS: getEnvInt("TIME_MULTIPLICATIONS_MS", 1000),
+		TimeDivisionMS:       getEnvInt("TIME_DIVISIONS_MS", 1000),
+		TimePowerMS:          getEnvInt("TIME_POWER_MS", 1000),
+		TimeModuloMS:         getEnvInt("TIME_MODULO_MS", 1000),
+		TimeIntDivisionMS:    getEnvInt("TIME_INTEGER_DIVISIONS_MS", 1000),
+		LeaseGraceMS:         getEnvInt("LEASE_GRACE_MS", 5000),
+		ReaperIntervalMS:     getEnvPositiveInt("REAPER_INTERVAL_MS", 1000),
+		MaxAttempts:          getEnvInt("TASK_MAX_ATTEMPTS", 3),
+		RetryBackoffMS:       getEnvInt("TASK_RETRY_BACKOFF_MS", 1000),
+		ExpressionTTL:        getEnvDuration("EXPRESSION_TTL", 0),
+		CacheErrors:          os.Getenv("CACHE_ERRORS") == "TRUE",
+		TimeFunctionsMS:      newFunctionTimes(),
+		Constants:            getEnvConstants("CONSTANTS"),
+	}
+}
+
+// newFunctionTimes reads TIME_<FUNCTION>_MS like TIME_SQRT_MS for every function,
+// TIME_FUNCTIONS_MS is used for the functions without their own setting
+func newFunctionTimes() map[string]int {
+	fallback := getEnvInt("TIME_FUNCTIONS_MS", 1000)
+
+	times := make(map[string]int)
+	for _, name := range calc.Functions() {
+		times[name] = getEnvInt("TIME_"+strings.ToUpper(name)+"_MS", fallback)
+	}
+	return times
+}
+
+func (c *Config) GetOperationTime(operation string) int {
+	switch operation {
+	case "+":
+		return c.TimeAdditionMS
+	case "-":
+		return c.TimeSubtractionMS
+	case "*":
+		return c.TimeMultiplicationMS
+	case "/":
+		return c.TimeDivisionMS
+	case "^":
+		return c.TimePowerMS
+	case "%":
+		return c.TimeModuloMS
+	case "//":
+		return c.TimeIntDivisionMS
+	}
+
+	return c.TimeFunctionsMS[operation]
+}
+
+// GetRetryBackoff returns a delay before the next dispatch of a task failed after the given attempts
+func (c *Config) GetRetryBackoff(attempts int) time.Duration {
+	backoff := time.Duration(c.RetryBackoffMS) * time.Millisecond
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+	}
+	return backoff
+}
+
+// getEnvInt reads an integer environment variable, fallback is used when it is not set
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Log.Fatal(err)
+	}
+	return result
+}
+
+// getEnvPositiveInt reads an integer environment variable that must be greater than zero
+func getEnvPositiveInt(key string, fallback int) int {
+	result := getEnvInt(key, fallback)
+	if result <= 0 {
+		logger.Log.Fatalf("invalid %s %d, must be greater than zero", key, result)
+	}
+	return result
+}
+
+// getEnvConstants reads constants like "g=9.81,c=299792458"
+func getEnvConstants(key string) map[string]float64 {
+	constants := make(map[string]float64)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		name, valueStr, ok := strings.Cut(pair, "=")
+		if !ok {
+			logger.Log.Fatalf("invalid constant %q in %s, must be name=value", pair, key)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(valueStr), 64)
+		if err != nil {
+			logger.Log.Fatal(err)
+		}
+		constants[strings.TrimSpace(name)] = value
+	}
+	return constants
+}
+
+// getEnvDuration reads a duration environment variable like "24h", fallback is used when it is not set
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	result, err := time.ParseDuration(value)
+	if err != nil {
+		logger.Log.Fatal(err)
+	}
+	return result
+}
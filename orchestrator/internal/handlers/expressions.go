@@ -4,57 +4,84 @@ import (
 	"errors"
 	"github.com/gofiber/fiber/v3"
 	"github.com/google/uuid"
-	"github.com/redis/go-redis/v9"
 	"orchestrator/internal/constValues"
 	"orchestrator/internal/handlers/models"
+	"orchestrator/internal/storage"
 	"strconv"
+	"time"
 )
 
-// ListExpressions @Summary      Получить весь список выражений
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// ListExpressions @Summary      Получить список выражений
+// @Description  Выражения отсортированы по времени создания, для получения следующей страницы передайте next_cursor
 // @Tags         expressions
 // @Accept       json
 // @Produce      json
+// @Param        status query string false "Статус выражения" Enums(DONE, PROCESSING, ERROR, CANCELLED)
+// @Param        order  query string false "Порядок сортировки по времени создания" Enums(asc, desc) default(desc)
+// @Param        limit  query int    false "Количество выражений на странице" minimum(1) maximum(500) default(50)
+// @Param        cursor query string false "Курсор следующей страницы"
 // @Success      200  {object}  models.ListAllExpressionsResponse
+// @Failure      422  {object}  models.ApiError
 // @Failure      500  {object}  models.ApiError
 // @Router       /api/v1/expressions [get]
 func (a *Controller) ListExpressions(c fiber.Ctx) error {
-	result, err := a.Results.Keys(c.Context(), "*").Result()
-	expressions := []models.Expression{}
-	if err != nil && !errors.Is(err, redis.Nil) {
-		return sendError(c, fiber.StatusInternalServerError, err)
-	} else if errors.Is(err, redis.Nil) {
-		return c.Status(fiber.StatusOK).JSON(models.ListAllExpressionsResponse{Expressions: expressions})
+	var query models.ListExpressionsRequest
+	if err := c.Bind().Query(&query); err != nil {
+		return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidQueryError)
 	}
 
-	for _, id := range result {
-		value, err := a.Results.Get(c.Context(), id).Result()
-		if err != nil && !errors.Is(err, redis.Nil) {
-			return sendError(c, fiber.StatusInternalServerError, err)
-		}
-		switch value {
-		case constValues.Error:
-			expressions = append(expressions, models.Expression{
-				Id:     id,
-				Result: 0,
-				Status: constValues.Error,
-			})
-		case constValues.Processing:
-			expressions = append(expressions, models.Expression{
-				Id:     id,
-				Result: 0,
-				Status: value,
-			})
-		default:
-			r, _ := strconv.ParseFloat(value, 64)
-			expressions = append(expressions, models.Expression{
-				Id:     id,
-				Result: r,
-				Status: constValues.Done,
-			})
+	switch query.Status {
+	case "", constValues.Done, constValues.Processing, constValues.Error, constValues.Cancelled:
+	default:
+		return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidStatusError)
+	}
+
+	if query.Order != "" && query.Order != "asc" && query.Order != "desc" {
+		return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidOrderError)
+	}
+
+	if query.Limit == 0 {
+		query.Limit = defaultPageSize
+	}
+	if query.Limit < 0 || query.Limit > maxPageSize {
+		return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidLimitError)
+	}
+
+	opts := storage.ListOptions{
+		Status: query.Status,
+		Limit:  query.Limit,
+		Desc:   query.Order != "asc",
+	}
+	if query.Cursor != "" {
+		var err error
+		if opts.Cursor, err = storage.ParseCursor(query.Cursor); err != nil {
+			return sendError(c, fiber.StatusUnprocessableEntity, err)
 		}
 	}
 
-	return c.Status(fiber.StatusOK).JSON(&models.ListAllExpressionsResponse{Expressions: expressions})
+	page, err := a.Expressions.List(c.Context(), opts)
+	if err != nil {
+		return sendError(c, fiber.StatusInternalServerError, err)
+	}
+
+	expressions := make([]models.Expression, 0, len(page.Expressions))
+	for _, entry := range page.Expressions {
+		expressions = append(expressions, newExpression(entry.ID, entry.Record))
+	}
+
+	response := &models.ListAllExpressionsResponse{
+		Expressions: expressions,
+		Total:       page.Total,
+	}
+	if page.Next != nil {
+		response.NextCursor = page.Next.String()
+	}
+	return c.Status(fiber.StatusOK).JSON(response)
 }
 
 // GetById @Summary      Получить выражение по UUID
@@ -73,35 +100,100 @@ func (a *Controller) GetById(c fiber.Ctx) error {
 		return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidUuidError)
 	}
 
-	value, err := a.Results.Get(c.Context(), id).Result()
-	if err != nil && !errors.Is(err, redis.Nil) {
+	record, err := a.Expressions.Get(c.Context(), id)
+	if err != nil && !errors.Is(err, constValues.NotFoundError) {
 		return sendError(c, fiber.StatusInternalServerError, err)
-	} else if errors.Is(err, redis.Nil) {
+	} else if errors.Is(err, constValues.NotFoundError) {
 		return sendError(c, fiber.StatusNotFound, constValues.NotFoundError)
 	}
 
-	expression := models.Expression{}
-	switch value {
-	case constValues.Error:
-		expression = models.Expression{
-			Id:     id,
-			Result: 0,
-			Status: constValues.Error,
+	expression := newExpression(id, record)
+
+	return c.Status(fiber.StatusOK).JSON(&models.GetByIdExpressionResponse{Expression: expression})
+}
+
+// CancelExpression @Summary      Отменить вычисление выражения
+// @Description  Задачи, ещё не отправленные агентам, удаляются, а результаты уже отправленных задач игнорируются
+// @Tags         expressions
+// @Accept       json
+// @Produce      json
+// @Param        id path  string true  "UUID выражения"
+// @Success      200  {object}  models.GetByIdExpressionResponse
+// @Failure      404  {object}  models.ApiError
+// @Failure      409  {object}  models.ApiError
+// @Failure      422  {object}  models.ApiError
+// @Failure      500  {object}  models.ApiError
+// @Router       /api/v1/expressions/{id} [delete]
+func (a *Controller) CancelExpression(c fiber.Ctx) error {
+	id := c.Params("id")
+	if uuid.Validate(id) != nil {
+		return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidUuidError)
+	}
+
+	record, err := a.Expressions.Modify(c.Context(), id, func(record *models.InternalExpression) error {
+		if record.Status != constValues.Processing {
+			return constValues.ExpressionFinishedError
 		}
-	case constValues.Processing:
-		expression = models.Expression{
-			Id:     id,
-			Result: 0,
-			Status: value,
+
+		now := time.Now()
+		record.Status = constValues.Cancelled
+		record.FinishedAt = &now
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, constValues.NotFoundError) {
+			return sendError(c, fiber.StatusNotFound, constValues.NotFoundError)
 		}
-	default:
-		r, _ := strconv.ParseFloat(value, 64)
-		expression = models.Expression{
-			Id:     id,
-			Result: r,
-			Status: constValues.Done,
+		if errors.Is(err, constValues.ExpressionFinishedError) {
+			return sendError(c, fiber.StatusConflict, err)
 		}
+		return sendError(c, fiber.StatusInternalServerError, err)
 	}
 
-	return c.Status(fiber.StatusOK).JSON(&models.GetByIdExpressionResponse{Expression: expression})
+	if err := a.finishExpression(c.Context(), id, record); err != nil {
+		return sendError(c, fiber.StatusInternalServerError, err)
+	}
+
+	// a new submission of the same expression must not return the cancelled one
+	if err := a.Expressions.Uncache(c.Context(), storage.CacheKey(record), id); err != nil {
+		return sendError(c, fiber.StatusInternalServerError, err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(&models.GetByIdExpressionResponse{
+		Expression: newExpression(id, record),
+	})
+}
+
+// newExpression converts a stored expression record into the API model
+func newExpression(id string, record *models.InternalExpression) models.Expression {
+	// float expressions are stored without the mode, like the ones of older versions
+	mode := record.Mode
+	if mode == "" {
+		mode = constValues.ModeFloat
+	}
+
+	expression := models.Expression{
+		Id:         id,
+		Expression: record.Expression,
+		Variables:  record.Variables,
+		Mode:       mode,
+		Precision:  record.Precision,
+		Result:     record.Result,
+		Value:      record.Value,
+		Status:     record.Status,
+		Error:      record.Error,
+		TaskCount:  record.TaskCount,
+		CreatedAt:  record.CreatedAt,
+		StartedAt:  record.StartedAt,
+		FinishedAt: record.FinishedAt,
+	}
+
+	// complex results are stored like (1+2i) and returned by parts
+	if mode == constValues.ModeComplex && record.Value != "" {
+		if c, err := strconv.ParseComplex(record.Value, 128); err == nil {
+			expression.Complex = &models.Complex{Real: real(c), Imag: imag(c)}
+			expression.Value = ""
+		}
+	}
+	return expression
 }
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"orchestrator/internal/constValues"
+	"orchestrator/internal/handlers/models"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalculateError(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		id, _ := submit(t, h, "1/(2-2)")
+		solve(t, h)
+
+		expression := getExpression(t, h, id)
+		require.Equal(t, constValues.Error, expression.Status)
+		require.NotNil(t, expression.Error)
+		require.Equal(t, "DIVISION_BY_ZERO", expression.Error.Code)
+		require.Equal(t, "/", expression.Error.Operation)
+
+		// failed expressions are not served from the cache
+		retryId, status := submit(t, h, "1/(2-2)")
+		require.Equal(t, fiber.StatusCreated, status)
+		require.NotEqual(t, id, retryId)
+
+		// the parent of the failed task reports the error of its argument
+		id, _ = submit(t, h, "1/(2-2)+1")
+		solve(t, h)
+		require.Equal(t, "DIVISION_BY_ZERO", getExpression(t, h, id).Error.Code)
+	})
+}
+
+func TestExpressionDetails(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		id, _ := submit(t, h, "(1 + 2) * 3")
+
+		expression := getExpression(t, h, id)
+		require.Equal(t, "(1+2)*3", expression.Expression)
+		require.Equal(t, 2, expression.TaskCount)
+		require.NotNil(t, expression.CreatedAt)
+		require.Nil(t, expression.StartedAt)
+		require.Nil(t, expression.FinishedAt)
+
+		// the expression is started by the first dispatch of its tasks
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, nil))
+		expression = getExpression(t, h, id)
+		require.NotNil(t, expression.StartedAt)
+		require.Nil(t, expression.FinishedAt)
+		require.False(t, expression.StartedAt.Before(*expression.CreatedAt))
+	})
+}
+
+func TestListExpressions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		ids := map[string]bool{}
+		for _, expression := range []string{"1+1", "2+2", "3+3"} {
+			id, _ := submit(t, h, expression)
+			ids[id] = true
+		}
+
+		seen := map[string]bool{}
+		cursor := ""
+		for {
+			var page models.ListAllExpressionsResponse
+			target := "/api/v1/expressions?limit=2"
+			if cursor != "" {
+				target += "&cursor=" + cursor
+			}
+			require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, target, nil, &page))
+			require.Equal(t, int64(3), page.Total)
+
+			for _, expression := range page.Expressions {
+				require.False(t, seen[expression.Id])
+				seen[expression.Id] = true
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		require.Equal(t, ids, seen)
+
+		solve(t, h)
+
+		var done models.ListAllExpressionsResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/api/v1/expressions?status=DONE", nil, &done))
+		require.Len(t, done.Expressions, 3)
+
+		require.Equal(t, fiber.StatusUnprocessableEntity, doRequest(t, h, fiber.MethodGet, "/api/v1/expressions?status=UNKNOWN", nil, nil))
+	})
+}
+
+func TestCancelExpression(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		id, _ := submit(t, h, "(1+2)*(3+4)")
+
+		var resp models.GetByIdExpressionResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodDelete, "/api/v1/expressions/"+id, nil, &resp))
+		require.Equal(t, constValues.Cancelled, resp.Expression.Status)
+
+		require.Equal(t, fiber.StatusConflict, doRequest(t, h, fiber.MethodDelete, "/api/v1/expressions/"+id, nil, nil))
+		require.Equal(t, 0, solve(t, h))
+	})
+}
+
+func TestCancelDispatchedExpression(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		id, _ := submit(t, h, "3*4")
+
+		var task models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodDelete, "/api/v1/expressions/"+id, nil, nil))
+
+		// the result of the dispatched task is accepted and discarded
+		status := doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: 12.0, Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusOK, status)
+		require.Equal(t, constValues.Cancelled, getExpression(t, h, id).Status)
+	})
+}
+
+func TestCancelExpressionTasks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		id, _ := submit(t, h, "(1+2)*(3+4)")
+		record, err := h.Expressions.Get(context.Background(), id)
+		require.NoError(t, err)
+
+		var computed, dispatched models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &computed))
+		status := doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: computed.ID, Result: 3.0, Lease: computed.Lease}, nil)
+		require.Equal(t, fiber.StatusOK, status)
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &dispatched))
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodDelete, "/api/v1/expressions/"+id, nil, nil))
+
+		// only the dispatched task is kept until its result arrives
+		for _, taskId := range record.Tasks {
+			_, err := h.Tasks.Modify(context.Background(), taskId, func(task *models.InternalTask) error {
+				require.Equal(t, constValues.Cancelled, task.Result)
+				return nil
+			})
+			if taskId == dispatched.ID {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, constValues.NotFoundError)
+			}
+		}
+
+		status = doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: dispatched.ID, Result: 7.0, Lease: dispatched.Lease}, nil)
+		require.Equal(t, fiber.StatusOK, status)
+		_, err = h.Tasks.Modify(context.Background(), dispatched.ID, func(task *models.InternalTask) error { return nil })
+		require.ErrorIs(t, err, constValues.NotFoundError)
+	})
+}
@@ -0,0 +1,451 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http/httptest"
+	"net/url"
+	"orchestrator/internal/constValues"
+	"orchestrator/internal/handlers/models"
+	"orchestrator/internal/logger"
+	"orchestrator/internal/redisconf"
+	"orchestrator/internal/storage"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v3"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+// testStores creates every backend, PostgreSQL runs only when TEST_DATABASE_URL is set
+var testStores = map[string]func(t *testing.T) *storage.Store{
+	"memory": func(t *testing.T) *storage.Store {
+		return storage.NewMemory()
+	},
+	"sqlite": func(t *testing.T) *storage.Store {
+		store, err := storage.NewSQLite(context.Background(), filepath.Join(t.TempDir(), "test.db"))
+		require.NoError(t, err)
+		return store
+	},
+	"redis": func(t *testing.T) *storage.Store {
+		client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+		t.Cleanup(func() { client.Close() })
+
+		store, err := storage.NewRedis(client, storage.DefaultRedisPrefix)
+		require.NoError(t, err)
+		return store
+	},
+	"postgres": func(t *testing.T) *storage.Store {
+		dsn := os.Getenv("TEST_DATABASE_URL")
+		if dsn == "" {
+			t.Skip("TEST_DATABASE_URL is not set")
+		}
+		return newPostgresStore(t, dsn)
+	},
+}
+
+// newPostgresStore opens the PostgreSQL store in a new schema, which is dropped after the test
+func newPostgresStore(t *testing.T, dsn string) *storage.Store {
+	t.Helper()
+	ctx := context.Background()
+
+	db, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	schema := "test_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	_, err = db.ExecContext(ctx, `CREATE SCHEMA `+schema)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		// the store is never closed, so its connections are terminated before the schema is dropped
+		_, _ = db.ExecContext(ctx, `SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE application_name = $1`, schema)
+		_, _ = db.ExecContext(ctx, `DROP SCHEMA `+schema+` CASCADE`)
+	})
+
+	u, err := url.Parse(dsn)
+	require.NoError(t, err)
+	query := u.Query()
+	query.Set("search_path", schema)
+	query.Set("application_name", schema)
+	u.RawQuery = query.Encode()
+
+	store, err := storage.NewPostgres(ctx, u.String())
+	require.NoError(t, err)
+	return store
+}
+
+// forEachStore runs the test against a controller for every backend in testStores
+func forEachStore(t *testing.T, test func(t *testing.T, h *Controller)) {
+	for name, newStore := range testStores {
+		t.Run(name, func(t *testing.T) {
+			test(t, newTestController(t, newStore(t)))
+		})
+	}
+}
+
+func newTestController(t *testing.T, store *storage.Store) *Controller {
+	t.Helper()
+	logger.New(false, "")
+
+	h := &Controller{
+		app:         fiber.New(),
+		cfg:         newConfig(),
+		Expressions: store.Expressions,
+		Tasks:       store.Tasks,
+		Validator:   validator.New(),
+	}
+	h.mapRoutes()
+	return h
+}
+
+func doRequest(t *testing.T, h *Controller, method, target string, body interface{}, out interface{}) int {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, target, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := h.app.Test(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	if out != nil {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp.StatusCode
+}
+
+func submit(t *testing.T, h *Controller, expression string) (string, int) {
+	t.Helper()
+
+	var resp models.CalculateResponse
+	status := doRequest(t, h, fiber.MethodPost, "/api/v1/calculate", &models.CalculateRequest{Expression: expression}, &resp)
+	return resp.Id, status
+}
+
+// solve acts as an agent and computes all dispatched tasks until the queue is empty
+func solve(t *testing.T, h *Controller) int {
+	t.Helper()
+
+	solved := 0
+	for {
+		var task models.TaskResponse
+		if doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task) == fiber.StatusNotFound {
+			return solved
+		}
+
+		request := &models.TaskRequest{ID: task.ID, Lease: task.Lease}
+		switch task.Operation {
+		case "+":
+			request.Result = task.Arg1 + task.Arg2
+		case "-":
+			request.Result = task.Arg1 - task.Arg2
+		case "*":
+			request.Result = task.Arg1 * task.Arg2
+		case "/":
+			if task.Arg2 == 0 {
+				request.Result = constValues.Error
+				request.ErrorKind = constValues.ErrorKindFatal
+				request.ErrorCode = "DIVISION_BY_ZERO"
+				break
+			}
+			request.Result = task.Arg1 / task.Arg2
+		case "sqrt":
+			request.Result = math.Sqrt(task.Args[0])
+		case "max":
+			request.Result = slices.Max(task.Args)
+		}
+
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodPost, "/internal/task", request, nil))
+		solved++
+	}
+}
+
+func getExpression(t *testing.T, h *Controller, id string) models.Expression {
+	t.Helper()
+
+	var resp models.GetByIdExpressionResponse
+	require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/api/v1/expressions/"+id, nil, &resp))
+	return resp.Expression
+}
+
+func TestCalculate(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		id, status := submit(t, h, "2+2*2")
+		require.Equal(t, fiber.StatusCreated, status)
+		require.Equal(t, constValues.Processing, getExpression(t, h, id).Status)
+
+		require.Equal(t, 2, solve(t, h))
+
+		expression := getExpression(t, h, id)
+		require.Equal(t, constValues.Done, expression.Status)
+		require.Equal(t, 6.0, expression.Result)
+		require.NotNil(t, expression.StartedAt)
+		require.NotNil(t, expression.FinishedAt)
+	})
+}
+
+func TestCalculateInvalid(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		_, status := submit(t, h, "2+*2")
+		require.Equal(t, fiber.StatusUnprocessableEntity, status)
+	})
+}
+
+func TestCalculateSyntaxError(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		var resp models.ExpressionSyntaxError
+		status := doRequest(t, h, fiber.MethodPost, "/api/v1/calculate", &models.CalculateRequest{Expression: "2 + * 3"}, &resp)
+		require.Equal(t, fiber.StatusUnprocessableEntity, status)
+		require.Equal(t, 5, resp.Column)
+		require.Equal(t, "*", resp.Token)
+	})
+}
+
+func TestCalculateNumber(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		id, _ := submit(t, h, "7")
+		require.Equal(t, 1, solve(t, h))
+
+		expression := getExpression(t, h, id)
+		require.Equal(t, constValues.Done, expression.Status)
+		require.Equal(t, 7.0, expression.Result)
+	})
+}
+
+func TestCalculateFunctions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		id, _ := submit(t, h, "max(1, sqrt(16), 3) * 2")
+		require.Equal(t, 3, solve(t, h))
+
+		expression := getExpression(t, h, id)
+		require.Equal(t, constValues.Done, expression.Status)
+		require.Equal(t, 8.0, expression.Result)
+	})
+}
+
+func TestCalculateVariables(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		calculate := func(variables map[string]json.Number) (string, int) {
+			var resp models.CalculateResponse
+			status := doRequest(t, h, fiber.MethodPost, "/api/v1/calculate", &models.CalculateRequest{Expression: "x*2+y", Variables: variables}, &resp)
+			return resp.Id, status
+		}
+
+		id, status := calculate(map[string]json.Number{"x": "2", "y": "3"})
+		require.Equal(t, fiber.StatusCreated, status)
+		solve(t, h)
+
+		expression := getExpression(t, h, id)
+		require.Equal(t, 7.0, expression.Result)
+		require.Equal(t, map[string]json.Number{"x": "2", "y": "3"}, expression.Variables)
+
+		cachedId, status := calculate(map[string]json.Number{"y": "3", "x": "2"})
+		require.Equal(t, fiber.StatusOK, status)
+		require.Equal(t, id, cachedId)
+
+		otherId, status := calculate(map[string]json.Number{"x": "2", "y": "4"})
+		require.Equal(t, fiber.StatusCreated, status)
+		require.NotEqual(t, id, otherId)
+
+		_, status = calculate(nil)
+		require.Equal(t, fiber.StatusUnprocessableEntity, status)
+	})
+}
+
+func TestCalculateExactVariables(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		// a number and a string with a number are both bound with all digits
+		for _, x := range []interface{}{json.Number("12345678901234567891"), "12345678901234567891"} {
+			body := map[string]interface{}{
+				"expression": "x+1",
+				"mode":       constValues.ModeBigint,
+				"variables":  map[string]interface{}{"x": x},
+				"force":      true,
+			}
+			var resp models.CalculateResponse
+			require.Equal(t, fiber.StatusCreated, doRequest(t, h, fiber.MethodPost, "/api/v1/calculate", body, &resp))
+
+			var task models.TaskResponse
+			require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+			require.Equal(t, []string{"12345678901234567891", "1"}, task.Operands)
+			status := doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: "12345678901234567892", Lease: task.Lease}, nil)
+			require.Equal(t, fiber.StatusOK, status)
+
+			expression := getExpression(t, h, resp.Id)
+			require.Equal(t, "12345678901234567892", expression.Value)
+			require.Equal(t, json.Number("12345678901234567891"), expression.Variables["x"])
+		}
+
+		body := map[string]interface{}{"expression": "x+1", "variables": map[string]interface{}{"x": "1,5"}}
+		require.Equal(t, fiber.StatusUnprocessableEntity, doRequest(t, h, fiber.MethodPost, "/api/v1/calculate", body, nil))
+	})
+}
+
+func TestCalculateDecimal(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		calculate := func(request *models.CalculateRequest) (string, int) {
+			var resp models.CalculateResponse
+			status := doRequest(t, h, fiber.MethodPost, "/api/v1/calculate", request, &resp)
+			return resp.Id, status
+		}
+
+		id, status := calculate(&models.CalculateRequest{Expression: "(0.1+0,2)*3", Mode: constValues.ModeDecimal})
+		require.Equal(t, fiber.StatusCreated, status)
+
+		var task models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		require.Equal(t, constValues.ModeDecimal, task.Mode)
+		require.Equal(t, constValues.DefaultPrecision, task.Precision)
+		require.Equal(t, []string{"0.1", "0.2"}, task.Operands)
+
+		status = doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: 0.3, Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusUnprocessableEntity, status)
+		status = doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: "0.3", Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusOK, status)
+
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		require.Equal(t, []string{"0.3", "3"}, task.Operands)
+		status = doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: "0.9", Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusOK, status)
+
+		expression := getExpression(t, h, id)
+		require.Equal(t, constValues.Done, expression.Status)
+		require.Equal(t, constValues.ModeDecimal, expression.Mode)
+		require.Equal(t, "0.9", expression.Value)
+		require.Equal(t, 0.9, expression.Result)
+
+		// the same expression in another mode or with another precision is computed separately
+		otherId, status := calculate(&models.CalculateRequest{Expression: "(0.1+0.2)*3"})
+		require.Equal(t, fiber.StatusCreated, status)
+		require.NotEqual(t, id, otherId)
+		require.Equal(t, constValues.ModeFloat, getExpression(t, h, otherId).Mode)
+
+		otherId, status = calculate(&models.CalculateRequest{Expression: "(0.1+0.2)*3", Mode: constValues.ModeDecimal, Precision: 5})
+		require.Equal(t, fiber.StatusCreated, status)
+		require.NotEqual(t, id, otherId)
+
+		cachedId, status := calculate(&models.CalculateRequest{Expression: "(0.1+0.2)*3", Mode: constValues.ModeDecimal, Precision: 20})
+		require.Equal(t, fiber.StatusOK, status)
+		require.Equal(t, id, cachedId)
+
+		for _, request := range []*models.CalculateRequest{
+			{Expression: "1+1", Mode: "fixed"},
+			{Expression: "1+1", Precision: 5},
+			{Expression: "1+1", Mode: constValues.ModeDecimal, Precision: constValues.MaxPrecision + 1},
+			{Expression: "ln(2)", Mode: constValues.ModeDecimal},
+		} {
+			_, status = calculate(request)
+			require.Equal(t, fiber.StatusUnprocessableEntity, status, request)
+		}
+	})
+}
+
+func TestCalculateBigint(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		var resp models.CalculateResponse
+		status := doRequest(t, h, fiber.MethodPost, "/api/v1/calculate", &models.CalculateRequest{Expression: "2^64+1", Numeric: constValues.ModeBigint}, &resp)
+		require.Equal(t, fiber.StatusCreated, status)
+
+		var task models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		require.Equal(t, constValues.ModeBigint, task.Mode)
+		require.Equal(t, []string{"2", "64"}, task.Operands)
+
+		status = doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: "1.8e19", Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusUnprocessableEntity, status)
+		status = doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: "18446744073709551616", Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusOK, status)
+
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		require.Equal(t, []string{"18446744073709551616", "1"}, task.Operands)
+		status = doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: "18446744073709551617", Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusOK, status)
+
+		expression := getExpression(t, h, resp.Id)
+		require.Equal(t, constValues.ModeBigint, expression.Mode)
+		require.Equal(t, "18446744073709551617", expression.Value)
+
+		for _, request := range []*models.CalculateRequest{
+			{Expression: "1.5+1", Numeric: constValues.ModeBigint},
+			{Expression: "1+1", Mode: constValues.ModeDecimal, Numeric: constValues.ModeBigint},
+			{Expression: "1+1", Mode: constValues.ModeBigint, Precision: 5},
+			{Expression: "1e999999+1", Numeric: constValues.ModeBigint},
+			{Expression: "1e999999+1", Mode: constValues.ModeDecimal},
+		} {
+			status = doRequest(t, h, fiber.MethodPost, "/api/v1/calculate", request, nil)
+			require.Equal(t, fiber.StatusUnprocessableEntity, status, request)
+		}
+	})
+}
+
+func TestCalculateComplex(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		var resp models.CalculateResponse
+		status := doRequest(t, h, fiber.MethodPost, "/api/v1/calculate", &models.CalculateRequest{Expression: "(1+2i)*x", Mode: constValues.ModeComplex, Variables: map[string]json.Number{"x": "3"}}, &resp)
+		require.Equal(t, fiber.StatusCreated, status)
+
+		var task models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		require.Equal(t, constValues.ModeComplex, task.Mode)
+		require.Equal(t, []models.Complex{{Real: 1}, {Imag: 2}}, task.ComplexArgs)
+
+		status = doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: 1.0, Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusUnprocessableEntity, status)
+		status = doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: models.Complex{Real: 1, Imag: 2}, Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusOK, status)
+
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		require.Equal(t, []models.Complex{{Real: 1, Imag: 2}, {Real: 3}}, task.ComplexArgs)
+		status = doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: models.Complex{Real: 3, Imag: 6}, Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusOK, status)
+
+		expression := getExpression(t, h, resp.Id)
+		require.Equal(t, constValues.ModeComplex, expression.Mode)
+		require.Equal(t, &models.Complex{Real: 3, Imag: 6}, expression.Complex)
+		require.Equal(t, 3.0, expression.Result)
+		require.Empty(t, expression.Value)
+
+		status = doRequest(t, h, fiber.MethodPost, "/api/v1/calculate", &models.CalculateRequest{Expression: "1+2i"}, nil)
+		require.Equal(t, fiber.StatusUnprocessableEntity, status)
+	})
+}
+
+func TestRedisOptions(t *testing.T) {
+	logger.New(false, "")
+	t.Setenv("REDIS_ADDR", "sentinel-1:26379, sentinel-2:26379")
+	t.Setenv("REDIS_PASSWORD", "secret")
+	t.Setenv("REDIS_SENTINEL_MASTER", "calc")
+
+	opts, err := redisconf.NewOptions()
+	require.NoError(t, err)
+	require.Equal(t, []string{"sentinel-1:26379", "sentinel-2:26379"}, opts.Addrs)
+	require.Equal(t, "secret", opts.Password)
+	require.Equal(t, "calc", opts.MasterName)
+	require.Nil(t, opts.TLSConfig)
+
+	// keys of the prefix without a hash tag are spread over cluster slots
+	t.Setenv("REDIS_SENTINEL_MASTER", "")
+	t.Setenv("REDIS_CLUSTER", "TRUE")
+	t.Setenv("REDIS_PREFIX", "calc:")
+	_, err = newRedisStore(context.Background())
+	require.ErrorContains(t, err, "hash tag")
+}
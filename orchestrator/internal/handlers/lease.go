@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"orchestrator/internal/constValues"
+	"orchestrator/internal/handlers/models"
+	"orchestrator/internal/logger"
+	"time"
+)
+
+// claimTask takes the next ready task from the queue and leases it until its deadline in one step,
+// queue entries of tasks already dispatched by another poll or replica are skipped
+func (a *Controller) claimTask(ctx context.Context) (*models.TaskResponse, error) {
+	var resp *models.TaskResponse
+	task, err := a.Tasks.Claim(ctx, func(task *models.InternalTask) error {
+		if task.Result != "" {
+			return constValues.TaskUnavailableError
+		}
+		resp = a.getTaskResponse(task)
+		if resp == nil {
+			return constValues.TaskUnavailableError
+		}
+
+		duration := time.Duration(a.cfg.GetOperationTime(task.Operation)+a.cfg.LeaseGraceMS) * time.Millisecond
+		task.Result = constValues.Processing
+		task.Attempts++
+		task.Lease = uuid.New().String()
+		task.LeaseUntil = time.Now().Add(duration).UnixMilli()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := a.markStarted(ctx, task.ExpressionID); err != nil {
+		return nil, err
+	}
+
+	resp.Lease = task.Lease
+	return resp, nil
+}
+
+// checkLease verifies that the result is submitted for the current lease of the task
+func checkLease(task *models.InternalTask, lease string) error {
+	if task.Result != constValues.Processing || task.Lease != lease || time.Now().UnixMilli() > task.LeaseUntil {
+		return constValues.LeaseExpiredError
+	}
+	return nil
+}
+
+// runReaper periodically requeues dispatched tasks with expired leases,
+// publishes results that were stored without queueing their parents,
+// returns delayed tasks into the ready queue once their backoff is over
+// and deletes expressions whose retention time is over
+func (a *Controller) runReaper(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(a.cfg.ReaperIntervalMS) * time.Millisecond)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := a.requeueExpiredTasks(ctx); err != nil {
+			logger.Log.Errorf("Error requeueing expired tasks: %v", err)
+		}
+		if err := a.promoteDelayedTasks(ctx); err != nil {
+			logger.Log.Errorf("Error promoting delayed tasks: %v", err)
+		}
+		if err := a.expireExpressions(ctx); err != nil {
+			logger.Log.Errorf("Error expiring expressions: %v", err)
+		}
+	}
+}
+
+func (a *Controller) requeueExpiredTasks(ctx context.Context) error {
+	now := time.Now()
+	taskIds, err := a.Tasks.ExpiredLeases(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, taskId := range taskIds {
+		var expired, retry, cancelled, unpublished bool
+		task, err := a.Tasks.Modify(ctx, taskId, func(task *models.InternalTask) error {
+			cancelled = task.Result == constValues.Cancelled
+			expired = task.Result == constValues.Processing && task.LeaseUntil <= now.UnixMilli()
+			// the result was stored, but queueing the parent or scheduling the retry failed
+			unpublished = !cancelled && task.Result != constValues.Processing
+			if !expired {
+				return nil
+			}
+
+			retry = a.canRetry(task)
+			if retry {
+				releaseTask(task, "")
+			} else {
+				releaseTask(task, constValues.Error)
+				task.Error = newTaskError(task, constValues.ErrorCodeLeaseExpired, "no result received before the lease deadline")
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, constValues.NotFoundError) {
+			return err
+		}
+
+		if cancelled {
+			// no result will be accepted for a task of a cancelled expression
+			if err := a.Tasks.Delete(ctx, taskId); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch {
+		case expired && retry:
+			logger.Log.Infof("Lease of task %s expired, retrying", taskId)
+			err = a.scheduleRetry(ctx, task)
+		case expired:
+			logger.Log.Infof("Lease of task %s expired, no attempts left", taskId)
+			err = a.finishTask(ctx, task)
+		case !unpublished && task != nil:
+			// the task was leased again after the expired leases were read, the new lease is tracked
+			continue
+		case unpublished && task.Result == "":
+			logger.Log.Infof("Retry of task %s was not scheduled, scheduling it again", taskId)
+			err = a.scheduleRetry(ctx, task)
+		case unpublished:
+			logger.Log.Infof("Result of task %s was not published, publishing it again", taskId)
+			err = a.finishTask(ctx, task)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := a.Tasks.Release(ctx, taskId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseTask ends the current lease of the task and sets its result
+func releaseTask(task *models.InternalTask, result interface{}) {
+	task.Result = result
+	task.Lease = ""
+	task.LeaseUntil = 0
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"orchestrator/internal/constValues"
+	"orchestrator/internal/handlers/models"
+	"orchestrator/internal/storage"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetTaskLease(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		submit(t, h, "3*4")
+
+		var task models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		require.NotEmpty(t, task.Lease)
+
+		status := doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: 12.0, Lease: "stale"}, nil)
+		require.Equal(t, fiber.StatusConflict, status)
+
+		status = doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: 12.0, Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusOK, status)
+		require.Equal(t, 12.0, getExpression(t, h, task.ID).Result)
+	})
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		grace := h.cfg.LeaseGraceMS
+		// leases end before they are granted, so the reaper requeues the task at once
+		h.cfg.LeaseGraceMS = -h.cfg.TimeMultiplicationMS - 1000
+		h.cfg.RetryBackoffMS = 0
+		id, _ := submit(t, h, "3*4")
+
+		var task models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		require.NoError(t, h.requeueExpiredTasks(context.Background()))
+		require.NoError(t, h.promoteDelayedTasks(context.Background()))
+
+		status := doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: 12.0, Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusConflict, status)
+
+		h.cfg.LeaseGraceMS = grace
+		var retried models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &retried))
+		require.Equal(t, task.ID, retried.ID)
+		require.NotEqual(t, task.Lease, retried.Lease)
+
+		// the lease is still valid, so the reaper keeps the task
+		require.NoError(t, h.requeueExpiredTasks(context.Background()))
+		status = doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: retried.ID, Result: 12.0, Lease: retried.Lease}, nil)
+		require.Equal(t, fiber.StatusOK, status)
+
+		expression := getExpression(t, h, id)
+		require.Equal(t, constValues.Done, expression.Status)
+		require.Equal(t, 12.0, expression.Result)
+	})
+}
+
+// failingExpressions fails the next Modify of the wrapped store
+type failingExpressions struct {
+	storage.ExpressionStore
+	failModify bool
+}
+
+func (s *failingExpressions) Modify(ctx context.Context, id string, fn func(record *models.InternalExpression) error) (*models.InternalExpression, error) {
+	if s.failModify {
+		s.failModify = false
+		return nil, errors.New("modify failed")
+	}
+	return s.ExpressionStore.Modify(ctx, id, fn)
+}
+
+// expireLease moves the tracked lease deadline of the task into the past
+func expireLease(t *testing.T, h *Controller, id string) {
+	t.Helper()
+
+	_, err := h.Tasks.Lease(context.Background(), id, func(task *models.InternalTask) error {
+		task.LeaseUntil = time.Now().Add(-time.Second).UnixMilli()
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestReaperPublishesResult(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		tasks := &failingTasks{TaskStore: h.Tasks}
+		h.Tasks = tasks
+		id, _ := submit(t, h, "(1+2)*3")
+
+		var task models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		tasks.failEnqueue = true
+		status := doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: 3.0, Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusInternalServerError, status)
+
+		// the reaper queues the parent once the lease deadline of the stored result is over
+		expireLease(t, h, task.ID)
+		require.NoError(t, h.requeueExpiredTasks(context.Background()))
+		require.Equal(t, 1, solve(t, h))
+
+		expression := getExpression(t, h, id)
+		require.Equal(t, constValues.Done, expression.Status)
+		require.Equal(t, 9.0, expression.Result)
+
+		leased, err := h.Tasks.ExpiredLeases(context.Background(), time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		require.Empty(t, leased)
+	})
+}
+
+func TestReaperPublishesError(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		expressions := &failingExpressions{ExpressionStore: h.Expressions}
+		h.Expressions = expressions
+		id, _ := submit(t, h, "1/(2-2)+1")
+
+		var task models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		status := doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: 0.0, Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusOK, status)
+
+		// the error reaches the root task, but the expression is not updated
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		expressions.failModify = true
+		status = doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{
+			ID:        task.ID,
+			Result:    constValues.Error,
+			Lease:     task.Lease,
+			ErrorKind: constValues.ErrorKindFatal,
+			ErrorCode: "DIVISION_BY_ZERO",
+		}, nil)
+		require.Equal(t, fiber.StatusInternalServerError, status)
+		require.Equal(t, constValues.Processing, getExpression(t, h, id).Status)
+
+		expireLease(t, h, task.ID)
+		require.NoError(t, h.requeueExpiredTasks(context.Background()))
+		expression := getExpression(t, h, id)
+		require.Equal(t, constValues.Error, expression.Status)
+		require.Equal(t, "DIVISION_BY_ZERO", expression.Error.Code)
+	})
+}
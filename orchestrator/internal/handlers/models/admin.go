@@ -0,0 +1,10 @@
+package models
+
+type PurgeRequest struct {
+	Status    string `query:"status"`
+	OlderThan string `query:"older_than"`
+}
+
+type PurgeResponse struct {
+	Deleted int `json:"deleted" example:"10"`
+}
@@ -1,7 +1,19 @@
 package models
 
+import "encoding/json"
+
 type CalculateRequest struct {
 	Expression string `json:"expression,required" validate:"expression,required" example:"2+2"`
+	Force      bool   `json:"force" example:"false"`
+	// Variables are the values of identifiers in the expression, numbers or strings with numbers,
+	// the exact modes keep all digits of the values
+	Variables map[string]json.Number `json:"variables,omitempty" swaggertype:"object,number"`
+	// Mode is float by default, decimal and bigint compute the expression exactly, complex accepts imaginary numbers
+	Mode string `json:"mode,omitempty" example:"decimal" enums:"float,decimal,bigint,complex"`
+	// Numeric is an alias of Mode
+	Numeric string `json:"numeric,omitempty" example:"bigint" enums:"float,decimal,bigint,complex"`
+	// Precision is the number of digits after the decimal point kept in quotients of the decimal mode
+	Precision int `json:"precision,omitempty" example:"20"`
 }
 
 type CalculateResponse struct {
@@ -5,3 +5,13 @@ type ApiError struct {
 	Message string `json:"message"`
 	Code    int    `json:"status"`
 }
+
+// ExpressionSyntaxError is returned for expressions that can not be parsed
+type ExpressionSyntaxError struct {
+	Message string `json:"message" example:"invalid expression: unexpected token \"*\" at column 3"`
+	Code    int    `json:"status" example:"422"`
+	// Column is the position of the offending token, starting from 1
+	Column int `json:"column" example:"3"`
+	// Token is the offending token, empty at the end of the expression
+	Token string `json:"token" example:"*"`
+}
@@ -1,7 +1,21 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
+type ListExpressionsRequest struct {
+	Status string `query:"status"`
+	Cursor string `query:"cursor"`
+	Limit  int    `query:"limit"`
+	Order  string `query:"order"`
+}
+
 type ListAllExpressionsResponse struct {
 	Expressions []Expression `json:"expressions"`
+	Total       int64        `json:"total" example:"1"`
+	NextCursor  string       `json:"next_cursor,omitempty" example:"MTc0MDgzMDQwMDAwMDo5MjhiMzAzZi1jZmNjLTQ2ZjQtYWUyNC1hYWJiNzJiYmI3ZDk"`
 }
 
 type GetByIdExpressionResponse struct {
@@ -9,7 +23,44 @@ type GetByIdExpressionResponse struct {
 }
 
 type Expression struct {
-	Id     string  `json:"id" example:"928b303f-cfcc-46f4-ae24-aabb72bbb7d9"`
+	Id         string                 `json:"id" example:"928b303f-cfcc-46f4-ae24-aabb72bbb7d9"`
+	Expression string                 `json:"expression" example:"2+2*2"`
+	Variables  map[string]json.Number `json:"variables,omitempty" swaggertype:"object,number"`
+	Mode       string                 `json:"mode" example:"float" enums:"float,decimal,bigint,complex"`
+	Precision  int                    `json:"precision,omitempty" example:"20"`
+	// Result is approximated by float64 in the exact modes and is the real part in the complex mode
 	Result float64 `json:"result"`
-	Status string  `json:"status" example:"DONE"`
+	// Value is the exact result in the decimal and bigint modes
+	Value string `json:"value,omitempty" example:"0.3"`
+	// Complex is the result in the complex mode
+	Complex    *Complex         `json:"complex,omitempty"`
+	Status     string           `json:"status" example:"DONE" enums:"DONE,PROCESSING,ERROR,CANCELLED"`
+	Error      *ExpressionError `json:"error,omitempty"`
+	TaskCount  int              `json:"task_count" example:"2"`
+	CreatedAt  *time.Time       `json:"created_at,omitempty" example:"2025-03-01T12:00:00Z"`
+	StartedAt  *time.Time       `json:"started_at,omitempty" example:"2025-03-01T12:00:01Z"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty" example:"2025-03-01T12:00:03Z"`
+}
+
+type ExpressionError struct {
+	Code      string `json:"code" example:"DIVISION_BY_ZERO"`
+	Message   string `json:"message" example:"division by zero"`
+	TaskId    string `json:"task_id" example:"928b303f-cfcc-46f4-ae24-aabb72bbb7d9"`
+	Operation string `json:"operation" example:"/"`
+}
+
+type InternalExpression struct {
+	Expression string                 `json:"expression"`
+	Variables  map[string]json.Number `json:"variables,omitempty" swaggertype:"object,number"`
+	Mode       string                 `json:"mode,omitempty"`
+	Precision  int                    `json:"precision,omitempty"`
+	Status     string                 `json:"status"`
+	Result     float64                `json:"result"`
+	Value      string                 `json:"value,omitempty"`
+	Error      *ExpressionError       `json:"error,omitempty"`
+	TaskCount  int                    `json:"task_count"`
+	Tasks      []string               `json:"tasks,omitempty"`
+	CreatedAt  *time.Time             `json:"created_at,omitempty"`
+	StartedAt  *time.Time             `json:"started_at,omitempty"`
+	FinishedAt *time.Time             `json:"finished_at,omitempty"`
 }
@@ -1,22 +1,72 @@
 package models
 
 type TaskResponse struct {
-	ID            string  `json:"id" example:"928b303f-cfcc-46f4-ae24-aabb72bbb7d9"`
-	Arg1          float64 `json:"arg1" example:"1"`
-	Arg2          float64 `json:"arg2" example:"1"`
-	Operation     string  `json:"operation" example:"+"`
-	OperationTime int     `json:"operation_time" example:"1000"`
+	ID   string  `json:"id" example:"928b303f-cfcc-46f4-ae24-aabb72bbb7d9"`
+	Arg1 float64 `json:"arg1" example:"1"`
+	Arg2 float64 `json:"arg2" example:"1"`
+	// Args are the arguments of functions, Arg1 and Arg2 are used by operators
+	Args []float64 `json:"args,omitempty"`
+	// Mode is empty for float tasks, other tasks get their arguments as Operands or ComplexArgs
+	Mode      string `json:"mode,omitempty" example:"decimal"`
+	Precision int    `json:"precision,omitempty" example:"20"`
+	// Operands are the decimal strings of Arg1 and Arg2 or of Args in the decimal and bigint modes
+	Operands []string `json:"operands,omitempty" example:"0.1,0.2"`
+	// ComplexArgs are Arg1 and Arg2 or Args in the complex mode
+	ComplexArgs   []Complex `json:"complex_args,omitempty"`
+	Operation     string    `json:"operation" example:"+"`
+	OperationTime int       `json:"operation_time" example:"1000"`
+	Lease         string    `json:"lease" example:"5b1c5b4e-3c2b-4f7c-9d0a-1f2e3d4c5b6a"`
 }
 
 type InternalTask struct {
-	ID        string      `json:"id" example:"928b303f-cfcc-46f4-ae24-aabb72bbb7d9"`
-	Arg1      interface{} `json:"arg1" example:"1"`
-	Arg2      interface{} `json:"arg2" example:"928b303f-cfcc-46f4-ae24-aabb72bbb7d9"`
-	Operation string      `json:"operation" example:"-"`
-	Result    interface{} `json:"result" example:"0"`
+	ID   string      `json:"id" example:"928b303f-cfcc-46f4-ae24-aabb72bbb7d9"`
+	Arg1 interface{} `json:"arg1" example:"1"`
+	Arg2 interface{} `json:"arg2" example:"928b303f-cfcc-46f4-ae24-aabb72bbb7d9"`
+	// Args are the arguments of functions, Arg1 and Arg2 are used by operators
+	Args []interface{} `json:"args,omitempty"`
+	// Mode is empty for float tasks, the arguments of other tasks are Exact values
+	Mode         string           `json:"mode,omitempty"`
+	Precision    int              `json:"precision,omitempty"`
+	Operation    string           `json:"operation" example:"-"`
+	Result       interface{}      `json:"result" example:"0"`
+	Parent       string           `json:"parent,omitempty" example:"928b303f-cfcc-46f4-ae24-aabb72bbb7d9"`
+	ExpressionID string           `json:"expression_id" example:"928b303f-cfcc-46f4-ae24-aabb72bbb7d9"`
+	Lease        string           `json:"lease,omitempty"`
+	LeaseUntil   int64            `json:"lease_until,omitempty"`
+	Attempts     int              `json:"attempts"`
+	Error        *ExpressionError `json:"error,omitempty"`
 }
 
 type TaskRequest struct {
-	ID     string      `json:"id" example:"928b303f-cfcc-46f4-ae24-aabb72bbb7d9"`
-	Result interface{} `json:"result"`
+	ID           string      `json:"id" example:"928b303f-cfcc-46f4-ae24-aabb72bbb7d9"`
+	Result       interface{} `json:"result"`
+	Lease        string      `json:"lease" example:"5b1c5b4e-3c2b-4f7c-9d0a-1f2e3d4c5b6a"`
+	ErrorKind    string      `json:"error_kind,omitempty" example:"TRANSIENT"`
+	ErrorCode    string      `json:"error_code,omitempty" example:"TIMEOUT"`
+	ErrorMessage string      `json:"error_message,omitempty" example:"operation timed out"`
+}
+
+// Complex is a complex number, the agent sends it as the result of complex tasks
+type Complex struct {
+	Real float64 `json:"real" example:"11"`
+	Imag float64 `json:"imag" example:"2"`
+}
+
+// Exact is a known argument of a task in the exact and complex modes, complex numbers are written like (1+2i).
+// It is not a plain string to tell it apart from the ID of the task that computes the argument
+type Exact struct {
+	Value string `json:"value"`
+}
+
+// ExactValue returns the value of an Exact argument, also when the task was decoded from JSON
+func ExactValue(arg interface{}) (string, bool) {
+	switch v := arg.(type) {
+	case Exact:
+		return v.Value, true
+	case map[string]interface{}:
+		value, ok := v["value"].(string)
+		return value, ok
+	default:
+		return "", false
+	}
 }
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"orchestrator/internal/logger"
+	"orchestrator/internal/redisconf"
+	"orchestrator/internal/storage"
+	"os"
+	"time"
+)
+
+// defaultClusterPrefix keeps all keys in one slot of Redis Cluster
+const defaultClusterPrefix = "{calc}:"
+
+// maxConnectBackoff limits the delay between attempts to connect to Redis
+const maxConnectBackoff = 30 * time.Second
+
+// newRedisStore connects to a single Redis server, a Sentinel deployment or Redis Cluster
+// configured by the REDIS_* variables
+func newRedisStore(ctx context.Context) (*storage.Store, error) {
+	opts, err := redisconf.NewOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	cluster := os.Getenv("REDIS_CLUSTER") == "TRUE"
+	if cluster && opts.MasterName != "" {
+		return nil, errors.New("REDIS_CLUSTER and REDIS_SENTINEL_MASTER can not be used together")
+	}
+	if cluster && opts.DB != 0 {
+		return nil, errors.New("REDIS_DB is not supported by Redis Cluster")
+	}
+
+	prefix, ok := os.LookupEnv("REDIS_PREFIX")
+	if !ok {
+		prefix = storage.DefaultRedisPrefix
+		if cluster {
+			prefix = defaultClusterPrefix
+		}
+	}
+
+	client := redisconf.NewClient(opts, cluster)
+	store, err := storage.NewRedis(client, prefix)
+	if err == nil {
+		err = pingRedis(ctx, client)
+	}
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// pingRedis waits until Redis is reachable, the delay between attempts doubles up to maxConnectBackoff
+func pingRedis(ctx context.Context, client redis.UniversalClient) error {
+	attempts := getEnvInt("REDIS_CONNECT_ATTEMPTS", 10)
+	backoff := getEnvDuration("REDIS_CONNECT_BACKOFF", 500*time.Millisecond)
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = client.Ping(ctx).Err(); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+
+		logger.Log.Warnf("Redis is not available (attempt %d of %d), retrying in %s: %v", attempt, attempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxConnectBackoff)
+	}
+
+	return fmt.Errorf("redis is not available after %d attempts: %w", attempts, err)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"orchestrator/internal/constValues"
+	"orchestrator/internal/handlers/models"
+	"time"
+)
+
+// finishExpression cleans up the tasks of a finished expression and schedules its expiration
+func (a *Controller) finishExpression(ctx context.Context, id string, record *models.InternalExpression) error {
+	var err error
+	if record.Status == constValues.Cancelled {
+		err = a.cancelTasks(ctx, record.Tasks)
+	} else {
+		err = a.deleteTasks(ctx, record.Tasks)
+	}
+	if err != nil {
+		return err
+	}
+
+	if a.cfg.ExpressionTTL <= 0 || record.FinishedAt == nil {
+		return nil
+	}
+	return a.Expressions.Expire(ctx, id, record.FinishedAt.Add(a.cfg.ExpressionTTL))
+}
+
+// deleteTasks removes the intermediate tasks of a finished expression
+func (a *Controller) deleteTasks(ctx context.Context, taskIds []string) error {
+	for _, taskId := range taskIds {
+		if err := a.Tasks.Delete(ctx, taskId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expireExpressions deletes finished expressions whose retention time is over
+func (a *Controller) expireExpressions(ctx context.Context) error {
+	ids, err := a.Expressions.Expired(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := a.deleteExpression(ctx, id); err != nil && !errors.Is(err, constValues.ExpressionRunningError) {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeExpressions deletes finished expressions with the status created before the given time,
+// an empty status matches all finished expressions
+func (a *Controller) purgeExpressions(ctx context.Context, status string, createdBefore time.Time) (int, error) {
+	ids, err := a.Expressions.CreatedBefore(ctx, status, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, id := range ids {
+		err := a.deleteExpression(ctx, id)
+		if errors.Is(err, constValues.ExpressionRunningError) {
+			continue
+		} else if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// deleteExpression removes the expression with its tasks, the status is checked in the same
+// transaction as the deletion, so an expression that is still processing is never removed
+func (a *Controller) deleteExpression(ctx context.Context, id string) error {
+	record, err := a.Expressions.Delete(ctx, id, func(record *models.InternalExpression) error {
+		if record.Status == constValues.Processing {
+			return constValues.ExpressionRunningError
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if record == nil {
+		return nil
+	}
+	return a.deleteTasks(ctx, record.Tasks)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"orchestrator/internal/constValues"
+	"orchestrator/internal/handlers/models"
+	"orchestrator/internal/storage"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFinishedTaskCleanup(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		id, _ := submit(t, h, "(1+2)*3")
+
+		taskIds := []string{}
+		for {
+			var task models.TaskResponse
+			if doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task) == fiber.StatusNotFound {
+				break
+			}
+			taskIds = append(taskIds, task.ID)
+			status := doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: task.Arg1 + task.Arg2, Lease: task.Lease}, nil)
+			require.Equal(t, fiber.StatusOK, status)
+		}
+		require.Len(t, taskIds, 2)
+
+		// the tasks of the finished expression are removed, its result is kept
+		for _, taskId := range taskIds {
+			_, err := h.Tasks.Modify(context.Background(), taskId, func(task *models.InternalTask) error { return nil })
+			require.ErrorIs(t, err, constValues.NotFoundError)
+		}
+		require.Equal(t, constValues.Done, getExpression(t, h, id).Status)
+	})
+}
+
+func TestExpressionTTL(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		h.cfg.ExpressionTTL = time.Hour
+		kept, _ := submit(t, h, "1+2")
+		solve(t, h)
+
+		// the shortest retention time is over as soon as the expression is finished
+		h.cfg.ExpressionTTL = time.Nanosecond
+		expired, _ := submit(t, h, "2+3")
+		solve(t, h)
+		processing, _ := submit(t, h, "3+4")
+
+		require.NoError(t, h.expireExpressions(context.Background()))
+		require.Equal(t, constValues.Done, getExpression(t, h, kept).Status)
+		require.Equal(t, constValues.Processing, getExpression(t, h, processing).Status)
+		require.Equal(t, fiber.StatusNotFound, doRequest(t, h, fiber.MethodGet, "/api/v1/expressions/"+expired, nil, nil))
+
+		// the expired expression is computed again instead of being served from the cache
+		_, status := submit(t, h, "2+3")
+		require.Equal(t, fiber.StatusCreated, status)
+	})
+}
+
+func TestPurgeExpressions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		done, _ := submit(t, h, "1+1")
+		solve(t, h)
+		pending, _ := submit(t, h, "2+2")
+		// only expressions created before the request are purged
+		time.Sleep(2 * time.Millisecond)
+
+		var resp models.PurgeResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodDelete, "/admin/expressions", nil, &resp))
+		require.Equal(t, 1, resp.Deleted)
+
+		require.Equal(t, fiber.StatusNotFound, doRequest(t, h, fiber.MethodGet, "/api/v1/expressions/"+done, nil, nil))
+		require.Equal(t, constValues.Processing, getExpression(t, h, pending).Status)
+	})
+}
+
+// staleExpressions lists every expression and reports it as done,
+// as a read made before the status of the expression changed would
+type staleExpressions struct {
+	storage.ExpressionStore
+}
+
+func (s *staleExpressions) Get(ctx context.Context, id string) (*models.InternalExpression, error) {
+	record, err := s.ExpressionStore.Get(ctx, id)
+	if record != nil {
+		record.Status = constValues.Done
+	}
+	return record, err
+}
+
+func (s *staleExpressions) CreatedBefore(ctx context.Context, _ string, before time.Time) ([]string, error) {
+	return s.ExpressionStore.CreatedBefore(ctx, "", before)
+}
+
+func TestPurgeExpressionsStaleStatus(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		pending, _ := submit(t, h, "2+2")
+		time.Sleep(2 * time.Millisecond)
+
+		expressions := h.Expressions
+		h.Expressions = &staleExpressions{ExpressionStore: expressions}
+		var resp models.PurgeResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodDelete, "/admin/expressions?status=DONE", nil, &resp))
+		require.Equal(t, 0, resp.Deleted)
+
+		h.Expressions = expressions
+		require.Equal(t, constValues.Processing, getExpression(t, h, pending).Status)
+		solve(t, h)
+		require.Equal(t, 4.0, getExpression(t, h, pending).Result)
+	})
+}
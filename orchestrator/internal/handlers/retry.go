@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"context"
+	"orchestrator/internal/handlers/models"
+	"time"
+)
+
+// canRetry reports whether the failed task has attempts left
+func (a *Controller) canRetry(task *models.InternalTask) bool {
+	return task.Attempts < a.cfg.MaxAttempts
+}
+
+// scheduleRetry keeps the task out of the ready queue until its backoff is over
+func (a *Controller) scheduleRetry(ctx context.Context, task *models.InternalTask) error {
+	return a.Tasks.Delay(ctx, task.ID, time.Now().Add(a.cfg.GetRetryBackoff(task.Attempts)))
+}
+
+func (a *Controller) promoteDelayedTasks(ctx context.Context) error {
+	return a.Tasks.PromoteDelayed(ctx, time.Now())
+}
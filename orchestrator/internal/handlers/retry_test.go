@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"orchestrator/internal/constValues"
+	"orchestrator/internal/handlers/models"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaseExpiryAttempts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		h.cfg.LeaseGraceMS = -h.cfg.TimeMultiplicationMS - 1000
+		h.cfg.MaxAttempts = 1
+		id, _ := submit(t, h, "3*4")
+
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, nil))
+		require.NoError(t, h.requeueExpiredTasks(context.Background()))
+		require.NoError(t, h.promoteDelayedTasks(context.Background()))
+		require.Equal(t, fiber.StatusNotFound, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, nil))
+
+		expression := getExpression(t, h, id)
+		require.Equal(t, constValues.Error, expression.Status)
+		require.Equal(t, constValues.ErrorCodeLeaseExpired, expression.Error.Code)
+	})
+}
+
+func TestTaskRetry(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		h.cfg.MaxAttempts = 2
+		h.cfg.RetryBackoffMS = 0
+		id, _ := submit(t, h, "3*4")
+
+		for attempt := 1; attempt <= h.cfg.MaxAttempts; attempt++ {
+			var task models.TaskResponse
+			require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+			request := &models.TaskRequest{
+				ID:        task.ID,
+				Lease:     task.Lease,
+				Result:    constValues.Error,
+				ErrorKind: constValues.ErrorKindTransient,
+				ErrorCode: "TIMEOUT",
+			}
+			require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodPost, "/internal/task", request, nil))
+			require.NoError(t, h.promoteDelayedTasks(context.Background()))
+		}
+
+		// no attempts are left after the second transient error
+		require.Equal(t, fiber.StatusNotFound, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, nil))
+		expression := getExpression(t, h, id)
+		require.Equal(t, constValues.Error, expression.Status)
+		require.Equal(t, "TIMEOUT", expression.Error.Code)
+	})
+}
+
+func TestTaskRetryBackoff(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		h.cfg.RetryBackoffMS = int(time.Hour / time.Millisecond)
+		id, _ := submit(t, h, "3*4")
+
+		var task models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		request := &models.TaskRequest{ID: task.ID, Lease: task.Lease, Result: constValues.Error, ErrorKind: constValues.ErrorKindTransient}
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodPost, "/internal/task", request, nil))
+
+		// the task waits for its backoff out of the ready queue
+		require.NoError(t, h.promoteDelayedTasks(context.Background()))
+		require.Equal(t, fiber.StatusNotFound, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, nil))
+		require.Equal(t, constValues.Processing, getExpression(t, h, id).Status)
+	})
+}
+
+func TestGetRetryBackoff(t *testing.T) {
+	cfg := &Config{RetryBackoffMS: 500}
+	require.Equal(t, 500*time.Millisecond, cfg.GetRetryBackoff(1))
+	require.Equal(t, time.Second, cfg.GetRetryBackoff(2))
+	require.Equal(t, 2*time.Second, cfg.GetRetryBackoff(3))
+}
@@ -1,10 +1,9 @@
 package handlers
 
 import (
-	"encoding/json"
 	"errors"
 	"github.com/gofiber/fiber/v3"
-	"github.com/redis/go-redis/v9"
+	"orchestrator/internal/calc"
 	"orchestrator/internal/constValues"
 	"orchestrator/internal/handlers/models"
 )
@@ -18,41 +17,14 @@ import (
 // @Failure      500  {object}  models.ApiError
 // @Router       /internal/task [get]
 func (a *Controller) GetTask(c fiber.Ctx) error {
-	ctx := c.Context()
-	taskIds, err := a.Tasks.Keys(ctx, "*").Result()
+	resp, err := a.claimTask(c.Context())
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
+		if errors.Is(err, constValues.NotFoundError) {
 			return sendError(c, fiber.StatusNotFound, constValues.NotFoundError)
 		}
 		return sendError(c, fiber.StatusInternalServerError, err)
 	}
-
-	for _, taskId := range taskIds {
-		task, err := a.getTask(ctx, taskId)
-		if err != nil || task.Result != "" {
-			continue
-		}
-
-		hasError, err := a.processTaskArguments(ctx, taskId, task)
-		if err != nil {
-			return sendError(c, fiber.StatusInternalServerError, err)
-		}
-		if hasError {
-			continue
-		}
-
-		if resp := a.getTaskResponse(task); resp != nil {
-			task.Result = constValues.Processing
-			err := a.updateTask(ctx, taskId, task)
-			if err != nil {
-				return sendError(c, fiber.StatusInternalServerError, err)
-			}
-			return c.Status(fiber.StatusOK).JSON(&resp)
-		}
-		continue
-	}
-
-	return sendError(c, fiber.StatusNotFound, constValues.NotFoundError)
+	return c.Status(fiber.StatusOK).JSON(&resp)
 }
 
 // SetTask @Summary      Обновить результат выражения
@@ -62,6 +34,7 @@ func (a *Controller) GetTask(c fiber.Ctx) error {
 // @Param        body body  models.TaskRequest true  "Объект, содержащий в себе результат части выражения"
 // @Success      200  {object}  models.ApiError
 // @Failure      404  {object}  models.ApiError
+// @Failure      409  {object}  models.ApiError
 // @Failure      422  {object}  models.ApiError
 // @Failure      500  {object}  models.ApiError
 // @Router       /internal/task [post]
@@ -75,29 +48,75 @@ func (a *Controller) SetTask(c fiber.Ctx) error {
 		return sendError(c, fiber.StatusUnprocessableEntity, constValues.InvalidJsonError)
 	}
 
-	taskStr, err := a.Tasks.Get(c.Context(), body.ID).Result()
-	if err != nil {
-		return sendError(c, fiber.StatusNotFound, err)
-	}
+	retry, cancelled := false, false
+	task, err := a.Tasks.Modify(c.Context(), body.ID, func(task *models.InternalTask) error {
+		cancelled = task.Result == constValues.Cancelled
+		if cancelled {
+			return nil
+		}
 
-	var task models.InternalTask
-	if err := json.Unmarshal([]byte(taskStr), &task); err != nil {
-		return sendError(c, fiber.StatusInternalServerError, err)
-	}
+		// the result is checked against the mode of the task
+		result := body.Result
+		if result != constValues.Error {
+			value, err := taskResult(task, result)
+			if err != nil {
+				return err
+			}
+			result = value
+		}
+		if err := checkLease(task, body.Lease); err != nil {
+			return err
+		}
+
+		retry = result == constValues.Error && body.ErrorKind == constValues.ErrorKindTransient && a.canRetry(task)
+		if retry {
+			releaseTask(task, "")
+			return nil
+		}
 
-	task.Result = body.Result
-	marshal, err := json.Marshal(&task)
+		releaseTask(task, result)
+		if result == constValues.Error {
+			task.Error = newTaskError(task, body.ErrorCode, body.ErrorMessage)
+		}
+		return nil
+	})
 	if err != nil {
+		if errors.Is(err, constValues.NotFoundError) {
+			return sendError(c, fiber.StatusNotFound, constValues.NotFoundError)
+		}
+		if errors.Is(err, constValues.InvalidResultError) {
+			return sendError(c, fiber.StatusUnprocessableEntity, err)
+		}
+		if errors.Is(err, constValues.LeaseExpiredError) {
+			return sendError(c, fiber.StatusConflict, err)
+		}
 		return sendError(c, fiber.StatusInternalServerError, err)
 	}
 
-	if _, err := a.Results.Get(c.Context(), body.ID).Result(); err == nil {
-		if err := a.Results.Set(c.Context(), body.ID, task.Result, 0).Err(); err != nil {
+	if cancelled {
+		// the expression was cancelled after dispatch, the result is discarded
+		if err := a.Tasks.Delete(c.Context(), task.ID); err != nil {
 			return sendError(c, fiber.StatusInternalServerError, err)
 		}
+		return c.Status(fiber.StatusOK).JSON(
+			&fiber.Error{
+				Message: "ok",
+				Code:    fiber.StatusOK,
+			})
+	}
+
+	if retry {
+		err = a.scheduleRetry(c.Context(), task)
+	} else {
+		err = a.finishTask(c.Context(), task)
+	}
+	if err != nil {
+		return sendError(c, fiber.StatusInternalServerError, err)
 	}
 
-	if err := a.Tasks.Set(c.Context(), body.ID, string(marshal), 0).Err(); err != nil {
+	// the lease is tracked until the result is published, so a result stored
+	// without queueing its parent is not lost when publishing it fails
+	if err := a.Tasks.Release(c.Context(), task.ID); err != nil {
 		return sendError(c, fiber.StatusInternalServerError, err)
 	}
 
@@ -107,3 +126,38 @@ func (a *Controller) SetTask(c fiber.Ctx) error {
 			Code:    fiber.StatusOK,
 		})
 }
+
+// taskResult converts the result sent by the agent, results of exact tasks are kept as strings
+func taskResult(task *models.InternalTask, result interface{}) (interface{}, error) {
+	if task.Mode == "" {
+		value, err := convertResult(result)
+		if err != nil {
+			return nil, constValues.InvalidResultError
+		}
+		return value, nil
+	}
+
+	if task.Mode == constValues.ModeComplex {
+		return complexResult(result)
+	}
+
+	value, ok := result.(string)
+	if !ok || !calc.ValidResult(task.Mode, value) {
+		return nil, constValues.InvalidResultError
+	}
+	return value, nil
+}
+
+// complexResult converts the real and imaginary parts sent by the agent into the string of a complex argument
+func complexResult(result interface{}) (interface{}, error) {
+	parts, ok := result.(map[string]interface{})
+	if !ok {
+		return nil, constValues.InvalidResultError
+	}
+	realPart, ok1 := parts["real"].(float64)
+	imagPart, ok2 := parts["imag"].(float64)
+	if !ok1 || !ok2 {
+		return nil, constValues.InvalidResultError
+	}
+	return calc.FormatComplex(complex(realPart, imagPart)), nil
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"orchestrator/internal/constValues"
+	"orchestrator/internal/handlers/models"
+	"orchestrator/internal/storage"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetTaskQueue(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		id, _ := submit(t, h, "(1+2)*(3+4)")
+
+		// tasks are dispatched in the order of the expression
+		var first, second models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &first))
+		require.Equal(t, "+", first.Operation)
+		require.Equal(t, []float64{1, 2}, []float64{first.Arg1, first.Arg2})
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &second))
+		require.Equal(t, []float64{3, 4}, []float64{second.Arg1, second.Arg2})
+		require.Equal(t, fiber.StatusNotFound, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, nil))
+
+		// the root task is queued only when the results of both arguments are known
+		status := doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: first.ID, Result: 3.0, Lease: first.Lease}, nil)
+		require.Equal(t, fiber.StatusOK, status)
+		require.Equal(t, fiber.StatusNotFound, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, nil))
+
+		status = doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: second.ID, Result: 7.0, Lease: second.Lease}, nil)
+		require.Equal(t, fiber.StatusOK, status)
+
+		var root models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &root))
+		require.Equal(t, id, root.ID)
+		require.Equal(t, []float64{3, 7}, []float64{root.Arg1, root.Arg2})
+		require.Equal(t, fiber.StatusNotFound, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, nil))
+	})
+}
+
+func TestTaskErrorPropagation(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		id, _ := submit(t, h, "1/(2-2)+1")
+		// the failed division is the last computed task, its parent is never dispatched
+		require.Equal(t, 2, solve(t, h))
+		require.Equal(t, constValues.Error, getExpression(t, h, id).Status)
+	})
+}
+
+// failingTasks fails the next Enqueue of the wrapped store
+type failingTasks struct {
+	storage.TaskStore
+	failEnqueue bool
+}
+
+func (s *failingTasks) Enqueue(ctx context.Context, id string) error {
+	if s.failEnqueue {
+		s.failEnqueue = false
+		return errors.New("enqueue failed")
+	}
+	return s.TaskStore.Enqueue(ctx, id)
+}
+
+func TestSetTaskEnqueueFailure(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		tasks := &failingTasks{TaskStore: h.Tasks}
+		h.Tasks = tasks
+		submit(t, h, "(1+2)*3")
+
+		var task models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		tasks.failEnqueue = true
+		status := doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: 3.0, Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusInternalServerError, status)
+		require.Equal(t, fiber.StatusNotFound, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, nil))
+
+		// the result is stored, but the task is still tracked until its parent is queued
+		leased, err := h.Tasks.ExpiredLeases(context.Background(), time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		require.Equal(t, []string{task.ID}, leased)
+	})
+}
+
+func TestSetTaskInvalidResult(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		submit(t, h, "3*4")
+
+		var task models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		status := doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: task.ID, Result: "twelve", Lease: task.Lease}, nil)
+		require.Equal(t, fiber.StatusUnprocessableEntity, status)
+		status = doRequest(t, h, fiber.MethodPost, "/internal/task", &models.TaskRequest{ID: "unknown", Result: 12.0}, nil)
+		require.Equal(t, fiber.StatusNotFound, status)
+	})
+}
+
+func TestGetTaskClaim(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		const expressions = 8
+		for i := 0; i < expressions; i++ {
+			id, _ := submit(t, h, strconv.Itoa(i)+"+1")
+			// a duplicate queue entry must not dispatch the task twice
+			require.NoError(t, h.Tasks.Enqueue(context.Background(), id))
+		}
+
+		ids := make([]string, 2*expressions)
+		var wg sync.WaitGroup
+		for i := range ids {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var task models.TaskResponse
+				if doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task) == fiber.StatusOK {
+					ids[i] = task.ID
+				}
+			}()
+		}
+		wg.Wait()
+
+		dispatched := map[string]bool{}
+		for _, id := range ids {
+			if id == "" {
+				continue
+			}
+			require.False(t, dispatched[id], "task %s is dispatched twice", id)
+			dispatched[id] = true
+		}
+		require.Len(t, dispatched, expressions)
+	})
+}
+
+func TestGetTaskClaimFailure(t *testing.T) {
+	forEachStore(t, func(t *testing.T, h *Controller) {
+		id, _ := submit(t, h, "3*4")
+		_, err := h.Tasks.Claim(context.Background(), func(task *models.InternalTask) error {
+			return errors.New("claim failed")
+		})
+		require.Error(t, err)
+
+		// a task taken from the queue without a lease is dispatched again
+		require.NoError(t, h.Tasks.PromoteDelayed(context.Background(), time.Now().Add(time.Hour)))
+		var task models.TaskResponse
+		require.Equal(t, fiber.StatusOK, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, &task))
+		require.Equal(t, id, task.ID)
+		require.Equal(t, fiber.StatusNotFound, doRequest(t, h, fiber.MethodGet, "/internal/task", nil, nil))
+	})
+}
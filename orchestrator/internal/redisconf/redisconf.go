@@ -0,0 +1,105 @@
+// Package redisconf reads the connection settings of Redis shared by the orchestrator and the migration tool
+package redisconf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// NewOptions reads the addresses, credentials and TLS settings of Redis,
+// REDIS_ADDR is a comma separated list of cluster nodes or sentinels
+func NewOptions() (*redis.UniversalOptions, error) {
+	db := 0
+	if value := os.Getenv("REDIS_DB"); value != "" {
+		var err error
+		if db, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid REDIS_DB: %w", err)
+		}
+	}
+
+	opts := &redis.UniversalOptions{
+		Addrs:            ParseAddrs(os.Getenv("REDIS_ADDR")),
+		DB:               db,
+		Username:         os.Getenv("REDIS_USERNAME"),
+		Password:         os.Getenv("REDIS_PASSWORD"),
+		MasterName:       os.Getenv("REDIS_SENTINEL_MASTER"),
+		SentinelUsername: os.Getenv("REDIS_SENTINEL_USERNAME"),
+		SentinelPassword: os.Getenv("REDIS_SENTINEL_PASSWORD"),
+	}
+
+	if os.Getenv("REDIS_TLS") == "TRUE" {
+		tlsConfig, err := NewTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+
+	return opts, nil
+}
+
+// ParseAddrs splits a comma separated list of addresses, the local server is used for an empty list
+func ParseAddrs(value string) []string {
+	addrs := strings.Split(value, ",")
+	for i := range addrs {
+		addrs[i] = strings.TrimSpace(addrs[i])
+	}
+	if len(addrs) == 1 && addrs[0] == "" {
+		addrs = []string{"localhost:6379"}
+	}
+	return addrs
+}
+
+// NewTLSConfig trusts the system certificates and the CA from REDIS_TLS_CA_FILE,
+// a client certificate is presented when REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE are set
+func NewTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: os.Getenv("REDIS_TLS_SERVER_NAME"),
+	}
+
+	if caFile := os.Getenv("REDIS_TLS_CA_FILE"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	certFile, keyFile := os.Getenv("REDIS_TLS_CERT_FILE"), os.Getenv("REDIS_TLS_KEY_FILE")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// NewClient connects to Redis Cluster, to the master of a Sentinel deployment when MasterName is set
+// or to a single server
+func NewClient(opts *redis.UniversalOptions, cluster bool) redis.UniversalClient {
+	switch {
+	case cluster:
+		return redis.NewClusterClient(opts.Cluster())
+	case opts.MasterName != "":
+		return redis.NewFailoverClient(opts.Failover())
+	default:
+		return redis.NewClient(opts.Simple())
+	}
+}
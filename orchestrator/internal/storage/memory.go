@@ -0,0 +1,381 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"orchestrator/internal/constValues"
+	"orchestrator/internal/handlers/models"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memory keeps all data of the process in maps guarded by a single mutex,
+// records are stored encoded, so callers never share state with the store
+type memory struct {
+	mu      sync.Mutex
+	cache   map[string]string
+	records map[string][]byte
+	expires map[string]time.Time
+	tasks   map[string][]byte
+	ready   []string
+	leased  map[string]time.Time
+	delayed map[string]time.Time
+}
+
+type memoryExpressions struct {
+	*memory
+}
+
+type memoryTasks struct {
+	*memory
+}
+
+// NewMemory creates a store that keeps everything in the memory of the process,
+// the data is lost on restart and is not shared between replicas
+func NewMemory() *Store {
+	m := &memory{
+		cache:   map[string]string{},
+		records: map[string][]byte{},
+		expires: map[string]time.Time{},
+		tasks:   map[string][]byte{},
+		leased:  map[string]time.Time{},
+		delayed: map[string]time.Time{},
+	}
+
+	return &Store{
+		Expressions: &memoryExpressions{m},
+		Tasks:       &memoryTasks{m},
+	}
+}
+
+func (s *memoryExpressions) Submit(_ context.Context, id string, record *models.InternalExpression, tasks []models.InternalTask, opts SubmitOptions) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := CacheKey(record)
+	if cachedId, ok := s.cache[key]; ok && !opts.Force {
+		if cached, err := s.getRecord(cachedId); err == nil && (opts.CacheErrors || cached.Status != constValues.Error) {
+			return cachedId, nil
+		}
+	}
+
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	encoded := make(map[string][]byte, len(tasks))
+	for i := range tasks {
+		if encoded[tasks[i].ID], err = json.Marshal(&tasks[i]); err != nil {
+			return "", err
+		}
+	}
+
+	s.cache[key] = id
+	s.records[id] = recordBytes
+	for i := range tasks {
+		s.tasks[tasks[i].ID] = encoded[tasks[i].ID]
+		if isReady(&tasks[i]) {
+			s.ready = append(s.ready, tasks[i].ID)
+		}
+	}
+	return id, nil
+}
+
+func (s *memoryExpressions) Get(_ context.Context, id string) (*models.InternalExpression, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.getRecord(id)
+}
+
+func (s *memoryExpressions) Modify(_ context.Context, id string, fn func(record *models.InternalExpression) error) (*models.InternalExpression, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.getRecord(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(record); err != nil {
+		return nil, err
+	}
+
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	s.records[id] = recordBytes
+	return record, nil
+}
+
+func (s *memoryExpressions) List(_ context.Context, opts ListOptions) (*ExpressionPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.filter(opts.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	// expressions created at the same time are ordered by ID, as in the Redis indexes
+	less := func(a, b *Cursor) bool {
+		return a.Score < b.Score || (a.Score == b.Score && a.ID < b.ID)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entryCursor(&entries[i]), entryCursor(&entries[j])
+		if opts.Desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+
+	page := &ExpressionPage{Expressions: []ExpressionEntry{}, Total: int64(len(entries))}
+	for i := range entries {
+		if opts.Cursor != nil {
+			current := entryCursor(&entries[i])
+			if (!opts.Desc && !less(opts.Cursor, current)) || (opts.Desc && !less(current, opts.Cursor)) {
+				continue
+			}
+		}
+
+		if len(page.Expressions) == opts.Limit {
+			last := page.Expressions[len(page.Expressions)-1]
+			page.Next = entryCursor(&last)
+			break
+		}
+		page.Expressions = append(page.Expressions, entries[i])
+	}
+	return page, nil
+}
+
+func (s *memoryExpressions) CreatedBefore(_ context.Context, status string, before time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.filter(status)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if createdScore(entry.Record) < before.UnixMilli() {
+			ids = append(ids, entry.ID)
+		}
+	}
+	return ids, nil
+}
+
+func (s *memoryExpressions) Uncache(_ context.Context, key, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cache[key] == id {
+		delete(s.cache, key)
+	}
+	return nil
+}
+
+func (s *memoryExpressions) Expire(_ context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expires[id] = at
+	return nil
+}
+
+func (s *memoryExpressions) Expired(_ context.Context, now time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return due(s.expires, now), nil
+}
+
+func (s *memoryExpressions) Delete(_ context.Context, id string, fn func(record *models.InternalExpression) error) (*models.InternalExpression, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.getRecord(id)
+	if err != nil && !errors.Is(err, constValues.NotFoundError) {
+		return nil, err
+	}
+	if record != nil {
+		if err := fn(record); err != nil {
+			return nil, err
+		}
+		if s.cache[CacheKey(record)] == id {
+			delete(s.cache, CacheKey(record))
+		}
+	}
+	delete(s.records, id)
+	delete(s.expires, id)
+	return record, nil
+}
+
+func (s *memory) getRecord(id string) (*models.InternalExpression, error) {
+	value, ok := s.records[id]
+	if !ok {
+		return nil, constValues.NotFoundError
+	}
+
+	var record models.InternalExpression
+	if err := json.Unmarshal(value, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// filter returns all expressions with the status, an empty status matches all expressions
+func (s *memory) filter(status string) ([]ExpressionEntry, error) {
+	entries := make([]ExpressionEntry, 0, len(s.records))
+	for id := range s.records {
+		record, err := s.getRecord(id)
+		if err != nil {
+			return nil, err
+		}
+		if status == "" || record.Status == status {
+			entries = append(entries, ExpressionEntry{ID: id, Record: record})
+		}
+	}
+	return entries, nil
+}
+
+func entryCursor(entry *ExpressionEntry) *Cursor {
+	return &Cursor{Score: createdScore(entry.Record), ID: entry.ID}
+}
+
+func (s *memoryTasks) Claim(_ context.Context, fn func(task *models.InternalTask) error) (*models.InternalTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.ready) > 0 {
+		id := s.ready[0]
+		s.ready = s.ready[1:]
+
+		task, err := s.modify(id, fn)
+		if errors.Is(err, constValues.NotFoundError) || errors.Is(err, constValues.TaskUnavailableError) {
+			continue
+		}
+		if err != nil {
+			// the task stays at the head of the queue
+			s.ready = append([]string{id}, s.ready...)
+			return nil, err
+		}
+		s.leased[id] = time.UnixMilli(task.LeaseUntil)
+		return task, nil
+	}
+	return nil, constValues.NotFoundError
+}
+
+func (s *memoryTasks) Enqueue(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ready = append(s.ready, id)
+	return nil
+}
+
+func (s *memoryTasks) Modify(_ context.Context, id string, fn func(task *models.InternalTask) error) (*models.InternalTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.modify(id, fn)
+}
+
+func (s *memoryTasks) Lease(_ context.Context, id string, fn func(task *models.InternalTask) error) (*models.InternalTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, err := s.modify(id, fn)
+	if err != nil {
+		return nil, err
+	}
+	s.leased[id] = time.UnixMilli(task.LeaseUntil)
+	return task, nil
+}
+
+func (s *memoryTasks) modify(id string, fn func(task *models.InternalTask) error) (*models.InternalTask, error) {
+	value, ok := s.tasks[id]
+	if !ok {
+		return nil, constValues.NotFoundError
+	}
+
+	var task models.InternalTask
+	if err := json.Unmarshal(value, &task); err != nil {
+		return nil, err
+	}
+	if err := fn(&task); err != nil {
+		return nil, err
+	}
+
+	taskBytes, err := json.Marshal(&task)
+	if err != nil {
+		return nil, err
+	}
+	s.tasks[id] = taskBytes
+	return &task, nil
+}
+
+func (s *memoryTasks) Release(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.leased, id)
+	return nil
+}
+
+func (s *memoryTasks) ExpiredLeases(_ context.Context, now time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return due(s.leased, now), nil
+}
+
+func (s *memoryTasks) Delay(_ context.Context, id string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delayed[id] = until
+	return nil
+}
+
+func (s *memoryTasks) PromoteDelayed(_ context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range due(s.delayed, now) {
+		delete(s.delayed, id)
+		s.ready = append(s.ready, id)
+	}
+	return nil
+}
+
+func (s *memoryTasks) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tasks, id)
+	delete(s.leased, id)
+	delete(s.delayed, id)
+	s.ready = slices.DeleteFunc(s.ready, func(readyId string) bool {
+		return readyId == id
+	})
+	return nil
+}
+
+// due returns the keys scheduled at or before now, ordered by their time
+func due(schedule map[string]time.Time, now time.Time) []string {
+	var ids []string
+	for id, at := range schedule {
+		if !at.After(now) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return schedule[ids[i]].Before(schedule[ids[j]])
+	})
+	return ids
+}
@@ -0,0 +1,44 @@
+-- times are stored as unix milliseconds, so the schema works on SQLite and PostgreSQL alike
+
+CREATE TABLE IF NOT EXISTS expressions (
+    id          TEXT PRIMARY KEY,
+    expression  TEXT NOT NULL,
+    status      TEXT NOT NULL,
+    result      DOUBLE PRECISION NOT NULL DEFAULT 0,
+    error       TEXT,
+    task_count  INTEGER NOT NULL DEFAULT 0,
+    tasks       TEXT,
+    created_at  BIGINT NOT NULL,
+    started_at  BIGINT,
+    finished_at BIGINT,
+    expires_at  BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS expressions_created_idx ON expressions (created_at, id);
+CREATE INDEX IF NOT EXISTS expressions_status_idx ON expressions (status, created_at, id);
+CREATE INDEX IF NOT EXISTS expressions_expires_idx ON expressions (expires_at);
+
+-- expression_cache points at the last computed expression with the same text
+CREATE TABLE IF NOT EXISTS expression_cache (
+    expression TEXT PRIMARY KEY,
+    id         TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS expression_cache_id_idx ON expression_cache (id);
+
+-- tasks of finished expressions are archived instead of deleted to keep the task tree
+CREATE TABLE IF NOT EXISTS tasks (
+    id            TEXT PRIMARY KEY,
+    expression_id TEXT NOT NULL,
+    parent        TEXT,
+    operation     TEXT NOT NULL,
+    data          TEXT NOT NULL,
+    queue         TEXT,
+    queued_at     BIGINT,
+    due_at        BIGINT,
+    archived      BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS tasks_expression_idx ON tasks (expression_id);
+CREATE INDEX IF NOT EXISTS tasks_ready_idx ON tasks (queue, queued_at, id);
+CREATE INDEX IF NOT EXISTS tasks_due_idx ON tasks (queue, due_at);
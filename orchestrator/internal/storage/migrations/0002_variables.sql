@@ -0,0 +1,3 @@
+-- variables are stored as a JSON object of the values bound to the identifiers of the expression
+
+ALTER TABLE expressions ADD COLUMN variables TEXT;
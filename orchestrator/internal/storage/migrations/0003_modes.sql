@@ -0,0 +1,5 @@
+-- exact modes keep the result as a decimal string, float expressions have no mode
+
+ALTER TABLE expressions ADD COLUMN numeric_mode TEXT;
+ALTER TABLE expressions ADD COLUMN decimal_places INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE expressions ADD COLUMN exact_result TEXT;
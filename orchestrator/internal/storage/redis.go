@@ -0,0 +1,627 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"orchestrator/internal/constValues"
+	"orchestrator/internal/handlers/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRedisPrefix is the namespace of all keys of the orchestrator
+const DefaultRedisPrefix = "calc:"
+
+// maxTxRetries limits retries of optimistic transactions on concurrent updates
+const maxTxRetries = 10
+
+// claimTimeout is the time after which a task taken from the ready queue and not leased,
+// e.g. by a replica that stopped in between, is queued again
+const claimTimeout = 30 * time.Second
+
+// staleCacheReply is the error code of submitScript when the cached expression has changed after it was read,
+// the reply has a message after the code, otherwise Redis prefixes it with ERR
+const staleCacheReply = "STALE_CACHE"
+
+// redisKeys builds the names of the keys in the namespace of the prefix,
+// the IDs stored in indexes and queues are not prefixed
+type redisKeys struct {
+	prefix string
+}
+
+// expression is a string with the ID of the last expression computed with the same CacheKey
+func (k redisKeys) expression(cacheKey string) string {
+	return k.prefix + "expr:" + cacheKey
+}
+
+// result is a string with the JSON record of the expression
+func (k redisKeys) result(id string) string {
+	return k.prefix + "result:" + id
+}
+
+// task is a string with the JSON of the task
+func (k redisKeys) task(id string) string {
+	return k.prefix + "task:" + id
+}
+
+// readyQueue is a list with IDs of tasks ready for dispatch
+func (k redisKeys) readyQueue() string {
+	return k.prefix + "queue:ready"
+}
+
+// leasedSet is a sorted set with IDs of dispatched tasks scored by lease deadline
+func (k redisKeys) leasedSet() string {
+	return k.prefix + "queue:leased"
+}
+
+// delayedSet is a sorted set with IDs of failed tasks scored by retry time
+func (k redisKeys) delayedSet() string {
+	return k.prefix + "queue:delayed"
+}
+
+// claimedSet is a sorted set with IDs of tasks taken from the ready queue and not leased yet,
+// scored by the time they are queued again
+func (k redisKeys) claimedSet() string {
+	return k.prefix + "queue:claimed"
+}
+
+// createdIndex is a sorted set with IDs of all expressions scored by creation time
+func (k redisKeys) createdIndex() string {
+	return k.prefix + "index:created"
+}
+
+// statusIndex is a sorted set with IDs of expressions in the status scored by creation time
+func (k redisKeys) statusIndex(status string) string {
+	return k.prefix + "index:status:" + status
+}
+
+// expirationIndex is a sorted set with IDs of finished expressions scored by expiration time
+func (k redisKeys) expirationIndex() string {
+	return k.prefix + "index:expires"
+}
+
+type redisExpressions struct {
+	client redis.UniversalClient
+	keys   redisKeys
+}
+
+type redisTasks struct {
+	client redis.UniversalClient
+	keys   redisKeys
+}
+
+// NewRedis keeps all data in the database of the client under the key prefix,
+// so several deployments can share one database. The connection is not checked, the caller pings the client.
+// Transactions and scripts touch several keys at once, so in Redis Cluster the prefix must be a hash tag like "{calc}:"
+func NewRedis(client redis.UniversalClient, prefix string) (*Store, error) {
+	if _, ok := client.(*redis.ClusterClient); ok && !hasHashTag(prefix) {
+		return nil, fmt.Errorf("prefix %q must contain a hash tag like {calc}: to keep all keys in one cluster slot", prefix)
+	}
+
+	keys := redisKeys{prefix: prefix}
+	return &Store{
+		Expressions: &redisExpressions{client: client, keys: keys},
+		Tasks:       &redisTasks{client: client, keys: keys},
+	}, nil
+}
+
+// submitScript atomically stores a new expression with all of its tasks,
+// unless the same expression was computed before and may be served from the cache.
+// The record of the cached expression is read by the caller beforehand and passed in KEYS,
+// so the script only touches declared keys, the script fails with staleCacheReply when the cache has changed since.
+//
+// KEYS: expression, record, creation index, processing index, ready queue, cached record, task keys...
+// ARGV: force, cache errors, expression ID, record, creation score, cached expression ID,
+// then a task ID, a task JSON and a ready flag for every task key
+var submitScript = redis.NewScript(`
+local function status(value)
+	local ok, record = pcall(cjson.decode, value)
+	if ok and type(record) == 'table' then
+		return record['status']
+	end
+	if value == 'ERROR' or value == 'PROCESSING' then
+		return value
+	end
+	return 'DONE'
+end
+
+if ARGV[1] ~= '1' then
+	local cached = redis.call('GET', KEYS[1]) or ''
+	if cached ~= ARGV[6] then
+		return redis.error_reply('STALE_CACHE the cached expression has changed')
+	end
+	if cached ~= '' then
+		local value = redis.call('GET', KEYS[6])
+		if value and (ARGV[2] == '1' or status(value) ~= 'ERROR') then
+			return cached
+		end
+	end
+end
+
+redis.call('SET', KEYS[1], ARGV[3])
+redis.call('SET', KEYS[2], ARGV[4])
+redis.call('ZADD', KEYS[3], ARGV[5], ARGV[3])
+redis.call('ZADD', KEYS[4], ARGV[5], ARGV[3])
+
+for i = 7, #KEYS do
+	local arg = 7 + (i - 7) * 3
+	redis.call('SET', KEYS[i], ARGV[arg + 1])
+	if ARGV[arg + 2] == '1' then
+		redis.call('RPUSH', KEYS[5], ARGV[arg])
+	end
+end
+
+return ARGV[3]
+`)
+
+func (s *redisExpressions) Submit(ctx context.Context, id string, record *models.InternalExpression, tasks []models.InternalTask, opts SubmitOptions) (string, error) {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+
+	cacheKey := s.keys.expression(CacheKey(record))
+	for attempt := 0; attempt < maxTxRetries; attempt++ {
+		cached, err := s.client.Get(ctx, cacheKey).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return "", err
+		}
+
+		// without a cached expression the record key is empty and never read
+		keys := []string{
+			cacheKey,
+			s.keys.result(id),
+			s.keys.createdIndex(),
+			s.keys.statusIndex(record.Status),
+			s.keys.readyQueue(),
+			s.keys.result(cached),
+		}
+		args := []interface{}{
+			opts.Force,
+			opts.CacheErrors,
+			id,
+			string(recordBytes),
+			createdScore(record),
+			cached,
+		}
+
+		for i := range tasks {
+			taskBytes, err := json.Marshal(&tasks[i])
+			if err != nil {
+				return "", err
+			}
+			keys = append(keys, s.keys.task(tasks[i].ID))
+			args = append(args, tasks[i].ID, string(taskBytes), isReady(&tasks[i]))
+		}
+
+		result, err := submitScript.Run(ctx, s.client, keys, args...).Text()
+		if err != nil && strings.HasPrefix(err.Error(), staleCacheReply+" ") {
+			continue
+		}
+		return result, err
+	}
+
+	return "", redis.TxFailedErr
+}
+
+func (s *redisExpressions) Get(ctx context.Context, id string) (*models.InternalExpression, error) {
+	value, err := s.client.Get(ctx, s.keys.result(id)).Result()
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	record := parseRecord(value)
+	return &record, nil
+}
+
+func (s *redisExpressions) Modify(ctx context.Context, id string, fn func(record *models.InternalExpression) error) (*models.InternalExpression, error) {
+	var record models.InternalExpression
+	key := s.keys.result(id)
+	txf := func(tx *redis.Tx) error {
+		value, err := tx.Get(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+
+		record = parseRecord(value)
+		oldStatus := record.Status
+		if err := fn(&record); err != nil {
+			return err
+		}
+
+		recordBytes, err := json.Marshal(&record)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, string(recordBytes), 0)
+			s.keys.indexExpression(ctx, pipe, id, &record, oldStatus)
+			return nil
+		})
+		return err
+	}
+
+	if err := watchKey(ctx, s.client, key, txf); err != nil {
+		return nil, notFound(err)
+	}
+	return &record, nil
+}
+
+func (s *redisExpressions) List(ctx context.Context, opts ListOptions) (*ExpressionPage, error) {
+	key := s.keys.createdIndex()
+	if opts.Status != "" {
+		key = s.keys.statusIndex(opts.Status)
+	}
+
+	total, err := s.client.ZCard(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var items []redis.Z
+	by := &redis.ZRangeBy{Min: "-inf", Max: "+inf"}
+	if opts.Cursor != nil {
+		// expressions created at the same time as the cursor are ordered by ID
+		score := strconv.FormatInt(opts.Cursor.Score, 10)
+		ties, err := s.rangeIndex(ctx, key, &redis.ZRangeBy{Min: score, Max: score}, opts.Desc)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range ties {
+			member := item.Member.(string)
+			if (!opts.Desc && member > opts.Cursor.ID) || (opts.Desc && member < opts.Cursor.ID) {
+				items = append(items, item)
+			}
+		}
+
+		if opts.Desc {
+			by.Max = "(" + score
+		} else {
+			by.Min = "(" + score
+		}
+	}
+
+	if len(items) <= opts.Limit {
+		by.Count = int64(opts.Limit + 1 - len(items))
+		rest, err := s.rangeIndex(ctx, key, by, opts.Desc)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, rest...)
+	}
+
+	page := &ExpressionPage{Expressions: []ExpressionEntry{}, Total: total}
+	if len(items) > opts.Limit {
+		items = items[:opts.Limit]
+		last := items[len(items)-1]
+		page.Next = &Cursor{Score: int64(last.Score), ID: last.Member.(string)}
+	}
+	if len(items) == 0 {
+		return page, nil
+	}
+
+	ids := make([]string, 0, len(items))
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.Member.(string))
+		keys = append(keys, s.keys.result(item.Member.(string)))
+	}
+
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		if value, ok := value.(string); ok {
+			record := parseRecord(value)
+			page.Expressions = append(page.Expressions, ExpressionEntry{ID: ids[i], Record: &record})
+		}
+	}
+	return page, nil
+}
+
+func (s *redisExpressions) rangeIndex(ctx context.Context, key string, by *redis.ZRangeBy, desc bool) ([]redis.Z, error) {
+	if desc {
+		return s.client.ZRevRangeByScoreWithScores(ctx, key, by).Result()
+	}
+	return s.client.ZRangeByScoreWithScores(ctx, key, by).Result()
+}
+
+func (s *redisExpressions) CreatedBefore(ctx context.Context, status string, before time.Time) ([]string, error) {
+	key := s.keys.createdIndex()
+	if status != "" {
+		key = s.keys.statusIndex(status)
+	}
+
+	return s.client.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(before.UnixMilli(), 10),
+	}).Result()
+}
+
+func (s *redisExpressions) Uncache(ctx context.Context, cacheKey, id string) error {
+	key := s.keys.expression(cacheKey)
+	cachedId, err := s.client.Get(ctx, key).Result()
+	if err == nil && cachedId == id {
+		err = s.client.Del(ctx, key).Err()
+	}
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	return nil
+}
+
+func (s *redisExpressions) Expire(ctx context.Context, id string, at time.Time) error {
+	return s.client.ZAdd(ctx, s.keys.expirationIndex(), redis.Z{
+		Score:  float64(at.UnixMilli()),
+		Member: id,
+	}).Err()
+}
+
+func (s *redisExpressions) Expired(ctx context.Context, now time.Time) ([]string, error) {
+	return s.client.ZRangeByScore(ctx, s.keys.expirationIndex(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.UnixMilli(), 10),
+	}).Result()
+}
+
+func (s *redisExpressions) Delete(ctx context.Context, id string, fn func(record *models.InternalExpression) error) (*models.InternalExpression, error) {
+	var record *models.InternalExpression
+	key := s.keys.result(id)
+	txf := func(tx *redis.Tx) error {
+		record = nil
+		value, err := tx.Get(ctx, key).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+
+		// the deduplication entry is removed only while it still points at the expression
+		cacheKey := ""
+		if err == nil {
+			found := parseRecord(value)
+			if err := fn(&found); err != nil {
+				return err
+			}
+			record = &found
+			if record.Expression != "" {
+				cacheKey = s.keys.expression(CacheKey(record))
+				if err := tx.Watch(ctx, cacheKey).Err(); err != nil {
+					return err
+				}
+				cachedId, err := tx.Get(ctx, cacheKey).Result()
+				if err != nil && !errors.Is(err, redis.Nil) {
+					return err
+				}
+				if cachedId != id {
+					cacheKey = ""
+				}
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if cacheKey != "" {
+				pipe.Del(ctx, cacheKey)
+			}
+			pipe.Del(ctx, key)
+			pipe.ZRem(ctx, s.keys.createdIndex(), id)
+			for _, status := range []string{constValues.Processing, constValues.Done, constValues.Error, constValues.Cancelled} {
+				pipe.ZRem(ctx, s.keys.statusIndex(status), id)
+			}
+			pipe.ZRem(ctx, s.keys.expirationIndex(), id)
+			return nil
+		})
+		return err
+	}
+
+	if err := watchKey(ctx, s.client, key, txf); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// indexExpression adds the expression to the creation time index and moves it to the index of its current status
+func (k redisKeys) indexExpression(ctx context.Context, pipe redis.Pipeliner, id string, record *models.InternalExpression, oldStatus string) {
+	member := redis.Z{Score: float64(createdScore(record)), Member: id}
+	pipe.ZAdd(ctx, k.createdIndex(), member)
+	if oldStatus != "" && oldStatus != record.Status {
+		pipe.ZRem(ctx, k.statusIndex(oldStatus), id)
+	}
+	pipe.ZAdd(ctx, k.statusIndex(record.Status), member)
+}
+
+// parseRecord decodes an expression record, plain values
+// stored by older versions are still supported
+func parseRecord(value string) models.InternalExpression {
+	var record models.InternalExpression
+	if err := json.Unmarshal([]byte(value), &record); err == nil {
+		return record
+	}
+
+	switch value {
+	case constValues.Error, constValues.Processing:
+		return models.InternalExpression{Status: value}
+	default:
+		r, _ := strconv.ParseFloat(value, 64)
+		return models.InternalExpression{Status: constValues.Done, Result: r}
+	}
+}
+
+// claimScript takes the next task ID from the ready queue and tracks it in the claimed set in one step,
+// so the task is queued again when it is not leased before claimTimeout
+//
+// KEYS: ready queue, claimed set
+// ARGV: the time the task is queued again
+var claimScript = redis.NewScript(`
+local id = redis.call('LPOP', KEYS[1])
+if id then
+	redis.call('ZADD', KEYS[2], ARGV[1], id)
+end
+return id
+`)
+
+func (s *redisTasks) Claim(ctx context.Context, fn func(task *models.InternalTask) error) (*models.InternalTask, error) {
+	keys := []string{s.keys.readyQueue(), s.keys.claimedSet()}
+	for {
+		id, err := claimScript.Run(ctx, s.client, keys, time.Now().Add(claimTimeout).UnixMilli()).Text()
+		if err != nil {
+			return nil, notFound(err)
+		}
+
+		// the lease removes the task from the claimed set in the same transaction
+		task, err := s.modify(ctx, id, fn, true)
+		if errors.Is(err, constValues.NotFoundError) || errors.Is(err, constValues.TaskUnavailableError) {
+			if err := s.client.ZRem(ctx, s.keys.claimedSet(), id).Err(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return task, nil
+	}
+}
+
+func (s *redisTasks) Enqueue(ctx context.Context, id string) error {
+	return s.client.RPush(ctx, s.keys.readyQueue(), id).Err()
+}
+
+func (s *redisTasks) Modify(ctx context.Context, id string, fn func(task *models.InternalTask) error) (*models.InternalTask, error) {
+	return s.modify(ctx, id, fn, false)
+}
+
+func (s *redisTasks) Lease(ctx context.Context, id string, fn func(task *models.InternalTask) error) (*models.InternalTask, error) {
+	return s.modify(ctx, id, fn, true)
+}
+
+// modify replaces the stored task with the result of fn, the lease deadline
+// is tracked in the same transaction, so a crash can't leave an untracked claim
+func (s *redisTasks) modify(ctx context.Context, id string, fn func(task *models.InternalTask) error, lease bool) (*models.InternalTask, error) {
+	var task *models.InternalTask
+	key := s.keys.task(id)
+	txf := func(tx *redis.Tx) error {
+		value, err := tx.Get(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+
+		task = &models.InternalTask{}
+		if err := json.Unmarshal([]byte(value), task); err != nil {
+			return err
+		}
+		if err := fn(task); err != nil {
+			return err
+		}
+
+		taskBytes, err := json.Marshal(task)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, string(taskBytes), 0)
+			if lease {
+				pipe.ZAdd(ctx, s.keys.leasedSet(), redis.Z{
+					Score:  float64(task.LeaseUntil),
+					Member: id,
+				})
+				pipe.ZRem(ctx, s.keys.claimedSet(), id)
+			}
+			return nil
+		})
+		return err
+	}
+
+	if err := watchKey(ctx, s.client, key, txf); err != nil {
+		return nil, notFound(err)
+	}
+	return task, nil
+}
+
+func (s *redisTasks) Release(ctx context.Context, id string) error {
+	return s.client.ZRem(ctx, s.keys.leasedSet(), id).Err()
+}
+
+func (s *redisTasks) ExpiredLeases(ctx context.Context, now time.Time) ([]string, error) {
+	return s.client.ZRangeByScore(ctx, s.keys.leasedSet(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.UnixMilli(), 10),
+	}).Result()
+}
+
+func (s *redisTasks) Delay(ctx context.Context, id string, until time.Time) error {
+	return s.client.ZAdd(ctx, s.keys.delayedSet(), redis.Z{
+		Score:  float64(until.UnixMilli()),
+		Member: id,
+	}).Err()
+}
+
+// promoteScript moves the IDs due before the time from the sorted sets into the ready queue in one step
+//
+// KEYS: ready queue, sorted sets...
+// ARGV: the time
+var promoteScript = redis.NewScript(`
+for i = 2, #KEYS do
+	local ids = redis.call('ZRANGEBYSCORE', KEYS[i], '-inf', ARGV[1])
+	for _, id in ipairs(ids) do
+		redis.call('ZREM', KEYS[i], id)
+		redis.call('RPUSH', KEYS[1], id)
+	end
+end
+return 0
+`)
+
+// PromoteDelayed also queues again the tasks whose claim was not followed by a lease
+func (s *redisTasks) PromoteDelayed(ctx context.Context, now time.Time) error {
+	keys := []string{s.keys.readyQueue(), s.keys.delayedSet(), s.keys.claimedSet()}
+	return promoteScript.Run(ctx, s.client, keys, now.UnixMilli()).Err()
+}
+
+func (s *redisTasks) Delete(ctx context.Context, id string) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.keys.task(id))
+		pipe.LRem(ctx, s.keys.readyQueue(), 0, id)
+		pipe.ZRem(ctx, s.keys.delayedSet(), id)
+		pipe.ZRem(ctx, s.keys.leasedSet(), id)
+		pipe.ZRem(ctx, s.keys.claimedSet(), id)
+		return nil
+	})
+	return err
+}
+
+// watchKey runs txf while watching the key, the transaction is retried
+// when the key is changed concurrently
+func watchKey(ctx context.Context, client redis.UniversalClient, key string, txf func(tx *redis.Tx) error) error {
+	for i := 0; i < maxTxRetries; i++ {
+		err := client.Watch(ctx, txf, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return err
+	}
+
+	return redis.TxFailedErr
+}
+
+// hasHashTag reports whether keys starting with the prefix are hashed by the tag in braces only
+func hasHashTag(prefix string) bool {
+	start := strings.IndexByte(prefix, '{')
+	if start < 0 {
+		return false
+	}
+	end := strings.IndexByte(prefix[start+1:], '}')
+	return end > 0
+}
+
+// notFound converts a missing key error of Redis into constValues.NotFoundError
+func notFound(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return constValues.NotFoundError
+	}
+	return err
+}
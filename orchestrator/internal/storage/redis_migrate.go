@@ -0,0 +1,319 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"orchestrator/internal/constValues"
+	"orchestrator/internal/handlers/models"
+	"orchestrator/internal/redisconf"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LegacyRedisLayout describes the databases used by versions that kept
+// expressions, results and tasks in separate Redis databases
+type LegacyRedisLayout struct {
+	ExpressionsDB int
+	ResultsDB     int
+	TasksDB       int
+}
+
+// DefaultLegacyRedisLayout is the layout of the versions before key namespacing
+var DefaultLegacyRedisLayout = LegacyRedisLayout{ExpressionsDB: 0, ResultsDB: 1, TasksDB: 2}
+
+// legacyIndexPrefix and legacyQueuePrefix are prefixes of the index and queue keys in the legacy layout
+const (
+	legacyIndexPrefix = "index:"
+	legacyQueuePrefix = "queue:"
+)
+
+// MigrateRedis copies the data of the legacy layout into namespaced keys in the database of opts,
+// expressions stored before the indexes were introduced are indexed on the way
+// and tasks stored before the dispatch queues were introduced are linked and queued.
+// The legacy keys are left intact, the number of copied keys is returned.
+// The legacy layout uses several databases, so a single server or a Sentinel deployment is expected
+func MigrateRedis(ctx context.Context, opts *redis.UniversalOptions, layout LegacyRedisLayout, prefix string) (int, error) {
+	target := redisconf.NewClient(opts, false)
+	defer target.Close()
+
+	if err := target.Ping(ctx).Err(); err != nil {
+		return 0, err
+	}
+
+	m := &redisMigration{target: target, db: opts.DB, keys: redisKeys{prefix: prefix}}
+	steps := []struct {
+		db      int
+		migrate func(ctx context.Context, source redis.UniversalClient, key string) error
+	}{
+		{layout.ExpressionsDB, m.migrateExpression},
+		{layout.ResultsDB, m.migrateResult},
+		{layout.TasksDB, m.migrateTask},
+	}
+
+	for _, step := range steps {
+		sourceOpts := *opts
+		sourceOpts.DB = step.db
+		source := redisconf.NewClient(&sourceOpts, false)
+
+		err := m.migrateDB(ctx, source, step.db, step.migrate)
+		_ = source.Close()
+		if err != nil {
+			return m.copied, fmt.Errorf("database %d: %w", step.db, err)
+		}
+	}
+
+	if err := m.rebuildTasks(ctx); err != nil {
+		return m.copied, err
+	}
+	if !m.indexed {
+		if err := m.rebuildIndex(ctx); err != nil {
+			return m.copied, err
+		}
+	}
+	return m.copied, nil
+}
+
+type redisMigration struct {
+	target redis.UniversalClient
+	// db is the database of the target
+	db     int
+	keys   redisKeys
+	copied int
+	// indexed is set when the legacy layout already has the creation index
+	indexed bool
+}
+
+func (m *redisMigration) migrateDB(ctx context.Context, source redis.UniversalClient, db int, migrate func(ctx context.Context, source redis.UniversalClient, key string) error) error {
+	iter := source.Scan(ctx, 0, "*", 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		// the target database may be one of the legacy ones
+		if db == m.db && strings.HasPrefix(key, m.keys.prefix) {
+			continue
+		}
+
+		if err := migrate(ctx, source, key); err != nil {
+			return fmt.Errorf("key %s: %w", key, err)
+		}
+		m.copied++
+	}
+	return iter.Err()
+}
+
+// migrateExpression copies a deduplication entry
+func (m *redisMigration) migrateExpression(ctx context.Context, source redis.UniversalClient, key string) error {
+	id, err := source.Get(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	return m.target.Set(ctx, m.keys.expression(key), id, 0).Err()
+}
+
+// migrateResult copies an expression record or one of the expression indexes
+func (m *redisMigration) migrateResult(ctx context.Context, source redis.UniversalClient, key string) error {
+	if name, ok := strings.CutPrefix(key, legacyIndexPrefix); ok {
+		if key == legacyIndexPrefix+"created" {
+			m.indexed = true
+		}
+		return m.copySortedSet(ctx, source, key, m.keys.prefix+legacyIndexPrefix+name)
+	}
+
+	value, err := source.Get(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	return m.target.Set(ctx, m.keys.result(key), value, 0).Err()
+}
+
+// migrateTask copies a task or one of the dispatch queues
+func (m *redisMigration) migrateTask(ctx context.Context, source redis.UniversalClient, key string) error {
+	switch key {
+	case legacyQueuePrefix + "ready":
+		ids, err := source.LRange(ctx, key, 0, -1).Result()
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		// duplicate entries of the ready queue are skipped on dispatch, so a repeated migration is harmless
+		values := make([]interface{}, 0, len(ids))
+		for _, id := range ids {
+			values = append(values, id)
+		}
+		return m.target.RPush(ctx, m.keys.readyQueue(), values...).Err()
+	case legacyQueuePrefix + "leased":
+		return m.copySortedSet(ctx, source, key, m.keys.leasedSet())
+	case legacyQueuePrefix + "delayed":
+		return m.copySortedSet(ctx, source, key, m.keys.delayedSet())
+	}
+
+	value, err := source.Get(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	return m.target.Set(ctx, m.keys.task(key), value, 0).Err()
+}
+
+func (m *redisMigration) copySortedSet(ctx context.Context, source redis.UniversalClient, from, to string) error {
+	members, err := source.ZRangeWithScores(ctx, from, 0, -1).Result()
+	if err != nil || len(members) == 0 {
+		return err
+	}
+	return m.target.ZAdd(ctx, to, members...).Err()
+}
+
+// rebuildIndex indexes the copied expressions of versions without the creation index
+func (m *redisMigration) rebuildIndex(ctx context.Context) error {
+	iter := m.target.Scan(ctx, 0, m.keys.result("*"), 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		value, err := m.target.Get(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+
+		record := parseRecord(value)
+		id := strings.TrimPrefix(key, m.keys.result(""))
+		_, err = m.target.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			m.keys.indexExpression(ctx, pipe, id, &record, "")
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// rebuildTasks links the copied tasks of versions without parent links and expression IDs,
+// the arguments computed before the migration are resolved and the ready tasks are queued
+func (m *redisMigration) rebuildTasks(ctx context.Context) error {
+	tasks := make(map[string]*models.InternalTask)
+	iter := m.target.Scan(ctx, 0, m.keys.task("*"), 0).Iterator()
+	for iter.Next(ctx) {
+		value, err := m.target.Get(ctx, iter.Val()).Result()
+		if err != nil {
+			return err
+		}
+
+		var task models.InternalTask
+		if err := json.Unmarshal([]byte(value), &task); err != nil {
+			return fmt.Errorf("key %s: %w", iter.Val(), err)
+		}
+		if task.ExpressionID == "" {
+			tasks[task.ID] = &task
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		for _, arg := range []interface{}{task.Arg1, task.Arg2} {
+			if id, ok := arg.(string); ok && tasks[id] != nil {
+				tasks[id].Parent = task.ID
+			}
+		}
+	}
+
+	// the root task of an expression has the ID of the expression
+	expressions := make(map[string][]*models.InternalTask)
+	for _, task := range tasks {
+		root := task
+		for root.Parent != "" {
+			root = tasks[root.Parent]
+		}
+		task.ExpressionID = root.ID
+		expressions[root.ID] = append(expressions[root.ID], task)
+	}
+
+	for id, expressionTasks := range expressions {
+		if err := m.rebuildExpression(ctx, id, expressionTasks, tasks); err != nil {
+			return fmt.Errorf("expression %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// rebuildExpression stores the linked tasks of the expression and queues the ready ones.
+// Legacy versions did not pass errors of tasks to their expressions, so such expressions are marked as failed
+func (m *redisMigration) rebuildExpression(ctx context.Context, id string, expressionTasks []*models.InternalTask, tasks map[string]*models.InternalTask) error {
+	failed := false
+	var ready, taskIds []string
+	for _, task := range expressionTasks {
+		taskIds = append(taskIds, task.ID)
+		if task.Result == constValues.Processing {
+			// legacy tasks are dispatched without a lease, so the task is dispatched again
+			task.Result = ""
+		}
+		if task.Result == constValues.Error {
+			failed = true
+		}
+		if task.Result != "" {
+			continue
+		}
+
+		task.Arg1 = legacyArgument(task.Arg1, tasks)
+		task.Arg2 = legacyArgument(task.Arg2, tasks)
+		if isReady(task) {
+			ready = append(ready, task.ID)
+		}
+	}
+	sort.Strings(taskIds)
+
+	value, err := m.target.Get(ctx, m.keys.result(id)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	record := parseRecord(value)
+	oldStatus := record.Status
+	record.TaskCount = len(taskIds)
+	record.Tasks = taskIds
+	if failed && record.Status == constValues.Processing {
+		now := time.Now()
+		record.Status = constValues.Error
+		record.FinishedAt = &now
+		record.Error = &models.ExpressionError{Code: constValues.ErrorCodeCalculation, Message: "a task failed before the migration"}
+	}
+
+	_, err = m.target.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, task := range expressionTasks {
+			taskBytes, err := json.Marshal(task)
+			if err != nil {
+				return err
+			}
+			pipe.Set(ctx, m.keys.task(task.ID), string(taskBytes), 0)
+		}
+		if value == "" {
+			return nil
+		}
+
+		recordBytes, err := json.Marshal(&record)
+		if err != nil {
+			return err
+		}
+		pipe.Set(ctx, m.keys.result(id), string(recordBytes), 0)
+		m.keys.indexExpression(ctx, pipe, id, &record, oldStatus)
+		if record.Status == constValues.Processing {
+			for _, taskId := range ready {
+				pipe.RPush(ctx, m.keys.readyQueue(), taskId)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// legacyArgument replaces the ID of a computed task with its result
+func legacyArgument(arg interface{}, tasks map[string]*models.InternalTask) interface{} {
+	id, ok := arg.(string)
+	if !ok || tasks[id] == nil {
+		return arg
+	}
+	if result, ok := tasks[id].Result.(float64); ok {
+		return result
+	}
+	return arg
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"orchestrator/internal/constValues"
+	"orchestrator/internal/handlers/models"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMigrateRedisBaseline migrates the layout of the first version, where tasks have no parent links,
+// computed arguments are resolved on dispatch and dispatched tasks have no lease
+func TestMigrateRedisBaseline(t *testing.T) {
+	server := miniredis.RunT(t)
+	legacy := map[int]map[string]string{
+		DefaultLegacyRedisLayout.ExpressionsDB: {
+			"1+2*3":       "sum",
+			"(1+2)*(3+4)": "product",
+			"1/(1-1)":     "quotient",
+		},
+		DefaultLegacyRedisLayout.ResultsDB: {
+			"sum":      constValues.Processing,
+			"product":  constValues.Processing,
+			"quotient": constValues.Processing,
+		},
+		DefaultLegacyRedisLayout.TasksDB: {
+			"mul":      `{"id":"mul","arg1":2,"arg2":3,"operation":"*","result":6}`,
+			"sum":      `{"id":"sum","arg1":1,"arg2":"mul","operation":"+","result":""}`,
+			"left":     `{"id":"left","arg1":1,"arg2":2,"operation":"+","result":"PROCESSING"}`,
+			"right":    `{"id":"right","arg1":3,"arg2":4,"operation":"+","result":""}`,
+			"product":  `{"id":"product","arg1":"left","arg2":"right","operation":"*","result":""}`,
+			"sub":      `{"id":"sub","arg1":1,"arg2":1,"operation":"-","result":0}`,
+			"quotient": `{"id":"quotient","arg1":1,"arg2":"sub","operation":"/","result":"ERROR"}`,
+		},
+	}
+	for db, values := range legacy {
+		for key, value := range values {
+			require.NoError(t, server.DB(db).Set(key, value))
+		}
+	}
+
+	opts := &redis.UniversalOptions{Addrs: []string{server.Addr()}, DB: 3}
+	copied, err := MigrateRedis(context.Background(), opts, DefaultLegacyRedisLayout, DefaultRedisPrefix)
+	require.NoError(t, err)
+	require.Equal(t, 13, copied)
+
+	client := redis.NewClient(opts.Simple())
+	defer client.Close()
+	store, err := NewRedis(client, DefaultRedisPrefix)
+	require.NoError(t, err)
+
+	getTask := func(id string) models.InternalTask {
+		t.Helper()
+		var task models.InternalTask
+		value, err := client.Get(context.Background(), DefaultRedisPrefix+"task:"+id).Result()
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal([]byte(value), &task))
+		return task
+	}
+
+	mul, sum := getTask("mul"), getTask("sum")
+	require.Equal(t, "sum", mul.Parent)
+	require.Equal(t, "sum", mul.ExpressionID)
+	require.Equal(t, "sum", sum.ExpressionID)
+	require.Equal(t, 6.0, sum.Arg2)
+
+	// the task dispatched by the legacy version is dispatched again
+	left := getTask("left")
+	require.Equal(t, "", left.Result)
+	require.Equal(t, "product", left.Parent)
+
+	var ready []string
+	for {
+		task, err := store.Tasks.Claim(context.Background(), func(task *models.InternalTask) error { return nil })
+		if err != nil {
+			require.ErrorIs(t, err, constValues.NotFoundError)
+			break
+		}
+		ready = append(ready, task.ID)
+	}
+	require.ElementsMatch(t, []string{"sum", "left", "right"}, ready)
+
+	record, err := store.Expressions.Get(context.Background(), "product")
+	require.NoError(t, err)
+	require.Equal(t, constValues.Processing, record.Status)
+	require.ElementsMatch(t, []string{"left", "right", "product"}, record.Tasks)
+
+	record, err = store.Expressions.Get(context.Background(), "quotient")
+	require.NoError(t, err)
+	require.Equal(t, constValues.Error, record.Status)
+	require.NotNil(t, record.Error)
+
+	page, err := store.Expressions.List(context.Background(), ListOptions{Status: constValues.Processing, Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), page.Total)
+}
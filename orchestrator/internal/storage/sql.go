@@ -0,0 +1,633 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"orchestrator/internal/constValues"
+	"orchestrator/internal/handlers/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// sqlDialect holds the parts of queries that differ between the supported databases
+type sqlDialect struct {
+	driver string
+	// forUpdate locks the selected rows until the end of the transaction
+	forUpdate string
+	// skipLocked lets concurrent polls skip the tasks claimed by each other
+	skipLocked string
+	// lockMigrations serializes migrations of replicas started at the same time until the end of the transaction,
+	// SQLite transactions take the lock of the database on begin and need no statement
+	lockMigrations string
+}
+
+// migrationLockKey is the key of the PostgreSQL advisory lock taken by migrations
+const migrationLockKey = "8416021937"
+
+var (
+	sqliteDialect   = sqlDialect{driver: "sqlite"}
+	postgresDialect = sqlDialect{
+		driver:         "pgx",
+		forUpdate:      " FOR UPDATE",
+		skipLocked:     " FOR UPDATE SKIP LOCKED",
+		lockMigrations: `SELECT pg_advisory_xact_lock(` + migrationLockKey + `)`,
+	}
+)
+
+type sqlExpressions struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+type sqlTasks struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// NewSQLite opens the SQLite database file, creating it when it doesn't exist
+func NewSQLite(ctx context.Context, path string) (*Store, error) {
+	// immediate transactions wait for the lock on begin instead of failing on the first write
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := sql.Open(sqliteDialect.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has a single writer, serializing the connections avoids busy errors in transactions
+	db.SetMaxOpenConns(1)
+
+	return newSQL(ctx, db, sqliteDialect)
+}
+
+// NewPostgres connects to the PostgreSQL database with the connection URL
+func NewPostgres(ctx context.Context, url string) (*Store, error) {
+	db, err := sql.Open(postgresDialect.driver, url)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSQL(ctx, db, postgresDialect)
+}
+
+func newSQL(ctx context.Context, db *sql.DB, dialect sqlDialect) (*Store, error) {
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err := migrate(ctx, db, dialect); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &Store{
+		Expressions: &sqlExpressions{db: db, dialect: dialect},
+		Tasks:       &sqlTasks{db: db, dialect: dialect},
+	}, nil
+}
+
+// migrate applies the embedded migrations that are not applied yet in the order of their versions,
+// a migration file is named after its version, e.g. 0001_init.sql
+func migrate(ctx context.Context, db *sql.DB, dialect sqlDialect) error {
+	// concurrent creation of the same table fails in PostgreSQL, so it is created under the lock too
+	err := inTx(ctx, db, func(tx *sql.Tx) error {
+		if err := lockMigrations(ctx, tx, dialect); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		versionStr, _, _ := strings.Cut(strings.TrimPrefix(name, "migrations/"), "_")
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return fmt.Errorf("invalid migration name %s", name)
+		}
+
+		script, err := migrations.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		err = inTx(ctx, db, func(tx *sql.Tx) error {
+			// the version is checked under the lock, so another replica can't apply it in between
+			if err := lockMigrations(ctx, tx, dialect); err != nil {
+				return err
+			}
+
+			var applied int
+			err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = $1`, version).Scan(&applied)
+			if err != nil || applied > 0 {
+				return err
+			}
+
+			if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+				return fmt.Errorf("migration %s: %w", name, err)
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lockMigrations(ctx context.Context, tx *sql.Tx, dialect sqlDialect) error {
+	if dialect.lockMigrations == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, dialect.lockMigrations)
+	return err
+}
+
+// inTx runs fn inside a transaction that is committed when fn succeeds
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+const expressionColumns = `id, expression, status, result, error, task_count, tasks, created_at, started_at, finished_at, variables, numeric_mode, decimal_places, exact_result`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanExpression(row rowScanner) (string, *models.InternalExpression, error) {
+	var (
+		id                             string
+		record                         models.InternalExpression
+		exprError, tasks, variables    sql.NullString
+		mode, value                    sql.NullString
+		createdAt, startedAt, finished sql.NullInt64
+	)
+	err := row.Scan(&id, &record.Expression, &record.Status, &record.Result, &exprError, &record.TaskCount, &tasks, &createdAt, &startedAt, &finished, &variables,
+		&mode, &record.Precision, &value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil, constValues.NotFoundError
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	if exprError.Valid {
+		record.Error = &models.ExpressionError{}
+		if err := json.Unmarshal([]byte(exprError.String), record.Error); err != nil {
+			return "", nil, err
+		}
+	}
+	if tasks.Valid {
+		if err := json.Unmarshal([]byte(tasks.String), &record.Tasks); err != nil {
+			return "", nil, err
+		}
+	}
+	if variables.Valid {
+		if err := json.Unmarshal([]byte(variables.String), &record.Variables); err != nil {
+			return "", nil, err
+		}
+	}
+	record.Mode = mode.String
+	record.Value = value.String
+	record.CreatedAt = fromMillis(createdAt)
+	record.StartedAt = fromMillis(startedAt)
+	record.FinishedAt = fromMillis(finished)
+	return id, &record, nil
+}
+
+// expressionValues returns the values of all expression columns except the ID
+func expressionValues(record *models.InternalExpression) ([]interface{}, error) {
+	var exprError, tasks, variables sql.NullString
+	if record.Error != nil {
+		data, err := json.Marshal(record.Error)
+		if err != nil {
+			return nil, err
+		}
+		exprError = sql.NullString{String: string(data), Valid: true}
+	}
+	if len(record.Tasks) > 0 {
+		data, err := json.Marshal(record.Tasks)
+		if err != nil {
+			return nil, err
+		}
+		tasks = sql.NullString{String: string(data), Valid: true}
+	}
+	if len(record.Variables) > 0 {
+		data, err := json.Marshal(record.Variables)
+		if err != nil {
+			return nil, err
+		}
+		variables = sql.NullString{String: string(data), Valid: true}
+	}
+
+	return []interface{}{
+		record.Expression,
+		record.Status,
+		record.Result,
+		exprError,
+		record.TaskCount,
+		tasks,
+		createdScore(record),
+		toMillis(record.StartedAt),
+		toMillis(record.FinishedAt),
+		variables,
+		sql.NullString{String: record.Mode, Valid: record.Mode != ""},
+		record.Precision,
+		sql.NullString{String: record.Value, Valid: record.Value != ""},
+	}, nil
+}
+
+func toMillis(t *time.Time) sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.UnixMilli(), Valid: true}
+}
+
+func fromMillis(value sql.NullInt64) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	t := time.UnixMilli(value.Int64)
+	return &t
+}
+
+func (s *sqlExpressions) Submit(ctx context.Context, id string, record *models.InternalExpression, tasks []models.InternalTask, opts SubmitOptions) (string, error) {
+	values, err := expressionValues(record)
+	if err != nil {
+		return "", err
+	}
+
+	key := CacheKey(record)
+	result := id
+	err = inTx(ctx, s.db, func(tx *sql.Tx) error {
+		inserted, err := tx.ExecContext(ctx, `INSERT INTO expression_cache (expression, id) VALUES ($1, $2) ON CONFLICT (expression) DO NOTHING`, key, id)
+		if err != nil {
+			return err
+		}
+		if count, err := inserted.RowsAffected(); err != nil {
+			return err
+		} else if count == 0 {
+			var cachedId string
+			err := tx.QueryRowContext(ctx, `SELECT id FROM expression_cache WHERE expression = $1`+s.dialect.forUpdate, key).Scan(&cachedId)
+			if err != nil {
+				return err
+			}
+
+			if !opts.Force {
+				var status string
+				err := tx.QueryRowContext(ctx, `SELECT status FROM expressions WHERE id = $1`, cachedId).Scan(&status)
+				if err != nil && !errors.Is(err, sql.ErrNoRows) {
+					return err
+				}
+				if err == nil && (opts.CacheErrors || status != constValues.Error) {
+					result = cachedId
+					return nil
+				}
+			}
+
+			if _, err := tx.ExecContext(ctx, `UPDATE expression_cache SET id = $2 WHERE expression = $1`, key, id); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO expressions (`+expressionColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+			append([]interface{}{id}, values...)...)
+		if err != nil {
+			return err
+		}
+
+		queuedAt := time.Now().UnixNano()
+		for i := range tasks {
+			data, err := json.Marshal(&tasks[i])
+			if err != nil {
+				return err
+			}
+
+			var queue sql.NullString
+			if isReady(&tasks[i]) {
+				queue = sql.NullString{String: "ready", Valid: true}
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO tasks (id, expression_id, parent, operation, data, queue, queued_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				tasks[i].ID, tasks[i].ExpressionID, tasks[i].Parent, tasks[i].Operation, string(data), queue, queuedAt+int64(i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+func (s *sqlExpressions) Get(ctx context.Context, id string) (*models.InternalExpression, error) {
+	_, record, err := scanExpression(s.db.QueryRowContext(ctx, `SELECT `+expressionColumns+` FROM expressions WHERE id = $1`, id))
+	return record, err
+}
+
+func (s *sqlExpressions) Modify(ctx context.Context, id string, fn func(record *models.InternalExpression) error) (*models.InternalExpression, error) {
+	var record *models.InternalExpression
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		_, record, err = scanExpression(tx.QueryRowContext(ctx, `SELECT `+expressionColumns+` FROM expressions WHERE id = $1`+s.dialect.forUpdate, id))
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+
+		values, err := expressionValues(record)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE expressions SET expression = $2, status = $3, result = $4, error = $5, task_count = $6,
+			tasks = $7, created_at = $8, started_at = $9, finished_at = $10, variables = $11,
+			numeric_mode = $12, decimal_places = $13, exact_result = $14 WHERE id = $1`, append([]interface{}{id}, values...)...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *sqlExpressions) List(ctx context.Context, opts ListOptions) (*ExpressionPage, error) {
+	var filter []string
+	var args []interface{}
+	if opts.Status != "" {
+		args = append(args, opts.Status)
+		filter = append(filter, "status = $1")
+	}
+
+	page := &ExpressionPage{Expressions: []ExpressionEntry{}}
+	countQuery := `SELECT COUNT(*) FROM expressions`
+	if len(filter) > 0 {
+		countQuery += ` WHERE ` + strings.Join(filter, " AND ")
+	}
+	if err := s.db.QueryRowContext(ctx, countQuery, args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	order, compare := "ASC", ">"
+	if opts.Desc {
+		order, compare = "DESC", "<"
+	}
+	if opts.Cursor != nil {
+		args = append(args, opts.Cursor.Score, opts.Cursor.ID)
+		filter = append(filter, fmt.Sprintf("(created_at, id) %s ($%d, $%d)", compare, len(args)-1, len(args)))
+	}
+
+	query := `SELECT ` + expressionColumns + ` FROM expressions`
+	if len(filter) > 0 {
+		query += ` WHERE ` + strings.Join(filter, " AND ")
+	}
+	args = append(args, opts.Limit+1)
+	query += fmt.Sprintf(` ORDER BY created_at %s, id %s LIMIT $%d`, order, order, len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		id, record, err := scanExpression(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Expressions = append(page.Expressions, ExpressionEntry{ID: id, Record: record})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Expressions) > opts.Limit {
+		page.Expressions = page.Expressions[:opts.Limit]
+		page.Next = entryCursor(&page.Expressions[len(page.Expressions)-1])
+	}
+	return page, nil
+}
+
+func (s *sqlExpressions) CreatedBefore(ctx context.Context, status string, before time.Time) ([]string, error) {
+	query := `SELECT id FROM expressions WHERE created_at < $1`
+	args := []interface{}{before.UnixMilli()}
+	if status != "" {
+		query += ` AND status = $2`
+		args = append(args, status)
+	}
+	return queryIds(ctx, s.db, query+` ORDER BY created_at, id`, args...)
+}
+
+func (s *sqlExpressions) Uncache(ctx context.Context, key, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM expression_cache WHERE expression = $1 AND id = $2`, key, id)
+	return err
+}
+
+func (s *sqlExpressions) Expire(ctx context.Context, id string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE expressions SET expires_at = $2 WHERE id = $1`, id, at.UnixMilli())
+	return err
+}
+
+func (s *sqlExpressions) Expired(ctx context.Context, now time.Time) ([]string, error) {
+	return queryIds(ctx, s.db, `SELECT id FROM expressions WHERE expires_at <= $1 ORDER BY expires_at`, now.UnixMilli())
+}
+
+// Delete also removes the archived tasks of the expression
+func (s *sqlExpressions) Delete(ctx context.Context, id string, fn func(record *models.InternalExpression) error) (*models.InternalExpression, error) {
+	var record *models.InternalExpression
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		_, found, err := scanExpression(tx.QueryRowContext(ctx, `SELECT `+expressionColumns+` FROM expressions WHERE id = $1`+s.dialect.forUpdate, id))
+		if err != nil && !errors.Is(err, constValues.NotFoundError) {
+			return err
+		}
+		if found != nil {
+			if err := fn(found); err != nil {
+				return err
+			}
+		}
+
+		record = found
+		for _, query := range []string{
+			`DELETE FROM expression_cache WHERE id = $1`,
+			`DELETE FROM tasks WHERE expression_id = $1`,
+			`DELETE FROM expressions WHERE id = $1`,
+		} {
+			if _, err := tx.ExecContext(ctx, query, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func queryIds(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *sqlTasks) Claim(ctx context.Context, fn func(task *models.InternalTask) error) (*models.InternalTask, error) {
+	for {
+		var task models.InternalTask
+		claimed := false
+		err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+			var id, data string
+			err := tx.QueryRowContext(ctx, `SELECT id, data FROM tasks WHERE queue = 'ready' ORDER BY queued_at, id LIMIT 1`+s.dialect.skipLocked).Scan(&id, &data)
+			if errors.Is(err, sql.ErrNoRows) {
+				return constValues.NotFoundError
+			} else if err != nil {
+				return err
+			}
+
+			if err := json.Unmarshal([]byte(data), &task); err != nil {
+				return err
+			}
+			if err := fn(&task); errors.Is(err, constValues.TaskUnavailableError) {
+				// the entry is dropped and the task is kept as it is
+				_, err = tx.ExecContext(ctx, `UPDATE tasks SET queue = NULL WHERE id = $1`, id)
+				return err
+			} else if err != nil {
+				return err
+			}
+
+			taskBytes, err := json.Marshal(&task)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `UPDATE tasks SET data = $2, queue = 'leased', due_at = $3 WHERE id = $1`, id, string(taskBytes), task.LeaseUntil)
+			claimed = err == nil
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if claimed {
+			return &task, nil
+		}
+	}
+}
+
+func (s *sqlTasks) Enqueue(ctx context.Context, id string) error {
+	// a leased task keeps tracking its lease, the entry would be dropped on claim anyway
+	_, err := s.db.ExecContext(ctx, `UPDATE tasks SET queue = 'ready', queued_at = $2, due_at = NULL WHERE id = $1 AND NOT archived AND (queue IS NULL OR queue <> 'leased')`,
+		id, time.Now().UnixNano())
+	return err
+}
+
+func (s *sqlTasks) Modify(ctx context.Context, id string, fn func(task *models.InternalTask) error) (*models.InternalTask, error) {
+	return s.modify(ctx, id, fn, false)
+}
+
+func (s *sqlTasks) Lease(ctx context.Context, id string, fn func(task *models.InternalTask) error) (*models.InternalTask, error) {
+	return s.modify(ctx, id, fn, true)
+}
+
+// modify replaces the stored task with the result of fn, the lease deadline
+// is tracked in the same transaction, so a crash can't leave an untracked claim
+func (s *sqlTasks) modify(ctx context.Context, id string, fn func(task *models.InternalTask) error, lease bool) (*models.InternalTask, error) {
+	var task models.InternalTask
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var data string
+		err := tx.QueryRowContext(ctx, `SELECT data FROM tasks WHERE id = $1 AND NOT archived`+s.dialect.forUpdate, id).Scan(&data)
+		if errors.Is(err, sql.ErrNoRows) {
+			return constValues.NotFoundError
+		} else if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal([]byte(data), &task); err != nil {
+			return err
+		}
+		if err := fn(&task); err != nil {
+			return err
+		}
+
+		taskBytes, err := json.Marshal(&task)
+		if err != nil {
+			return err
+		}
+
+		if lease {
+			_, err = tx.ExecContext(ctx, `UPDATE tasks SET data = $2, queue = 'leased', due_at = $3 WHERE id = $1`, id, string(taskBytes), task.LeaseUntil)
+		} else {
+			_, err = tx.ExecContext(ctx, `UPDATE tasks SET data = $2 WHERE id = $1`, id, string(taskBytes))
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (s *sqlTasks) Release(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE tasks SET queue = NULL, due_at = NULL WHERE id = $1 AND queue = 'leased'`, id)
+	return err
+}
+
+func (s *sqlTasks) ExpiredLeases(ctx context.Context, now time.Time) ([]string, error) {
+	return queryIds(ctx, s.db, `SELECT id FROM tasks WHERE queue = 'leased' AND due_at <= $1 ORDER BY due_at`, now.UnixMilli())
+}
+
+func (s *sqlTasks) Delay(ctx context.Context, id string, until time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE tasks SET queue = 'delayed', due_at = $2 WHERE id = $1 AND NOT archived`, id, until.UnixMilli())
+	return err
+}
+
+func (s *sqlTasks) PromoteDelayed(ctx context.Context, now time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE tasks SET queue = 'ready', queued_at = $2, due_at = NULL WHERE queue = 'delayed' AND due_at <= $1`,
+		now.UnixMilli(), time.Now().UnixNano())
+	return err
+}
+
+// Delete archives the task, it is kept for the history until its expression is deleted
+func (s *sqlTasks) Delete(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE tasks SET archived = TRUE, queue = NULL, due_at = NULL WHERE id = $1`, id)
+	return err
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"orchestrator/internal/constValues"
+	"orchestrator/internal/handlers/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExpressionStore keeps expression records, their deduplication entries and indexes.
+// Missing expressions are reported with constValues.NotFoundError
+type ExpressionStore interface {
+	// Submit stores a new expression with all of its tasks and puts the ready tasks into the dispatch queue,
+	// the ID of the same expression computed before is returned instead unless the cache is bypassed
+	Submit(ctx context.Context, id string, record *models.InternalExpression, tasks []models.InternalTask, opts SubmitOptions) (string, error)
+	Get(ctx context.Context, id string) (*models.InternalExpression, error)
+	// Modify applies fn to the expression inside a transaction and keeps the status index up to date
+	Modify(ctx context.Context, id string, fn func(record *models.InternalExpression) error) (*models.InternalExpression, error)
+	// List returns a page of expressions sorted by creation time
+	List(ctx context.Context, opts ListOptions) (*ExpressionPage, error)
+	// CreatedBefore returns IDs of expressions with the status created before the given time,
+	// an empty status matches all expressions
+	CreatedBefore(ctx context.Context, status string, before time.Time) ([]string, error)
+	// Uncache removes the deduplication entry with the CacheKey if it still points at the ID
+	Uncache(ctx context.Context, key, id string) error
+	// Expire schedules the deletion of the expression
+	Expire(ctx context.Context, id string, at time.Time) error
+	// Expired returns IDs of expressions scheduled for deletion before now
+	Expired(ctx context.Context, now time.Time) ([]string, error)
+	// Delete applies fn to the expression and removes it with its deduplication entry and its index entries
+	// inside one transaction, an error from fn keeps the expression. The removed record is returned, nil when
+	// the expression is missing; the tasks are deleted by the caller through the TaskStore
+	Delete(ctx context.Context, id string, fn func(record *models.InternalExpression) error) (*models.InternalExpression, error)
+}
+
+// TaskStore keeps tasks and their dispatch queues.
+// Missing tasks are reported with constValues.NotFoundError
+type TaskStore interface {
+	// Claim takes the next task from the ready queue and leases it with fn in one step, so a task leaves the queue
+	// only together with its lease. Entries of missing tasks and of tasks rejected by fn with
+	// constValues.TaskUnavailableError are dropped, constValues.NotFoundError is returned when the queue is empty
+	Claim(ctx context.Context, fn func(task *models.InternalTask) error) (*models.InternalTask, error)
+	Enqueue(ctx context.Context, id string) error
+	// Modify applies fn to the task inside a transaction
+	Modify(ctx context.Context, id string, fn func(task *models.InternalTask) error) (*models.InternalTask, error)
+	// Lease applies fn to the task and tracks its lease deadline in the same transaction
+	Lease(ctx context.Context, id string, fn func(task *models.InternalTask) error) (*models.InternalTask, error)
+	// Release stops tracking the lease deadline of the task
+	Release(ctx context.Context, id string) error
+	// ExpiredLeases returns IDs of tasks whose lease deadline is before now
+	ExpiredLeases(ctx context.Context, now time.Time) ([]string, error)
+	// Delay keeps the task out of the ready queue until the given time
+	Delay(ctx context.Context, id string, until time.Time) error
+	// PromoteDelayed moves tasks whose delay is over into the ready queue
+	PromoteDelayed(ctx context.Context, now time.Time) error
+	// Delete removes the task and its entries in the dispatch queues
+	Delete(ctx context.Context, id string) error
+}
+
+// Store groups the stores of one backend
+type Store struct {
+	Expressions ExpressionStore
+	Tasks       TaskStore
+}
+
+type SubmitOptions struct {
+	// Force bypasses the cache of computed expressions
+	Force bool
+	// CacheErrors allows returning cached expressions that failed
+	CacheErrors bool
+}
+
+type ListOptions struct {
+	// Status filters the expressions, empty matches all
+	Status string
+	// Cursor is the last expression of the previous page
+	Cursor *Cursor
+	Limit  int
+	Desc   bool
+}
+
+type ExpressionEntry struct {
+	ID     string
+	Record *models.InternalExpression
+}
+
+type ExpressionPage struct {
+	Expressions []ExpressionEntry
+	// Next is nil on the last page
+	Next *Cursor
+	// Total is the number of expressions matching the status filter
+	Total int64
+}
+
+// Cursor points at the last expression of a page
+type Cursor struct {
+	Score int64
+	ID    string
+}
+
+func (c *Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.Score, 10) + ":" + c.ID))
+}
+
+func ParseCursor(value string) (*Cursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, constValues.InvalidCursorError
+	}
+
+	scoreStr, id, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return nil, constValues.InvalidCursorError
+	}
+	score, err := strconv.ParseInt(scoreStr, 10, 64)
+	if err != nil {
+		return nil, constValues.InvalidCursorError
+	}
+
+	return &Cursor{Score: score, ID: id}, nil
+}
+
+// CacheKey returns the key of the deduplication entry of the expression,
+// the same expression with other variable values or in another mode is computed separately
+func CacheKey(record *models.InternalExpression) string {
+	key := record.Expression
+	if record.Mode != "" {
+		key = record.Mode + ":" + strconv.Itoa(record.Precision) + ":" + key
+	}
+	if len(record.Variables) == 0 {
+		return key
+	}
+
+	names := make([]string, 0, len(record.Variables))
+	for name := range record.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bindings := make([]string, 0, len(names))
+	for _, name := range names {
+		bindings = append(bindings, name+"="+record.Variables[name].String())
+	}
+	return key + "?" + strings.Join(bindings, "&")
+}
+
+// createdScore returns the index score of the expression, records of older versions have no creation time
+func createdScore(record *models.InternalExpression) int64 {
+	if record.CreatedAt == nil {
+		return 0
+	}
+	return record.CreatedAt.UnixMilli()
+}
+
+// isReady reports whether all arguments of the task are known, unknown arguments are IDs of other tasks
+func isReady(task *models.InternalTask) bool {
+	if task.Args != nil {
+		for _, arg := range task.Args {
+			if !isValue(arg) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return isValue(task.Arg1) && isValue(task.Arg2)
+}
+
+// isValue reports whether the argument is a float64 or an exact value
+func isValue(arg interface{}) bool {
+	if _, ok := arg.(float64); ok {
+		return true
+	}
+	_, ok := models.ExactValue(arg)
+	return ok
+}